@@ -1,10 +1,15 @@
 package app
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
-	"net"
+	"hash/fnv"
+	"io"
 	"net/http"
 	_ "net/http/pprof"
+	"net/netip"
+	"os"
 	"path"
 	"strings"
 	"time"
@@ -15,11 +20,24 @@ import (
 	"github.com/miekg/dns"
 
 	"github.com/hiwyw/dnscap-go/app/config"
+	"github.com/hiwyw/dnscap-go/app/filter"
 	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/handler/ampdetecter"
 	"github.com/hiwyw/dnscap-go/app/handler/analyzer"
+	"github.com/hiwyw/dnscap-go/app/handler/auditor"
+	"github.com/hiwyw/dnscap-go/app/handler/bypassdetecter"
+	"github.com/hiwyw/dnscap-go/app/handler/cachesim"
+	"github.com/hiwyw/dnscap-go/app/handler/correlator"
+	"github.com/hiwyw/dnscap-go/app/handler/dgadetecter"
+	"github.com/hiwyw/dnscap-go/app/handler/intel"
 	"github.com/hiwyw/dnscap-go/app/handler/logwriter"
+	"github.com/hiwyw/dnscap-go/app/handler/passivedns"
 	"github.com/hiwyw/dnscap-go/app/handler/qpswriter"
+	"github.com/hiwyw/dnscap-go/app/handler/rebinddetecter"
+	"github.com/hiwyw/dnscap-go/app/handler/spoofdetecter"
 	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+	"github.com/hiwyw/dnscap-go/app/pkg/publicsuffix"
 	"github.com/hiwyw/dnscap-go/app/session"
 	"github.com/hiwyw/dnscap-go/app/types"
 )
@@ -32,13 +50,10 @@ const (
 	promiscuous = true
 )
 
+// New builds an app with the handlers enabled in the config.
 func New(cfg *config.Config) *App {
-	a := &App{
-		cfg:          cfg,
-		sessionCache: session.New(cfg.SessionCacheSize),
-		handlers:     []handler.Handler{},
-		closeCh:      make(chan struct{}),
-	}
+	a := newApp(cfg, cfg.DnslogMode == config.DnslogModeTransaction)
+	classifier := a.classifier
 
 	if cfg.DnslogEnable {
 		h := logwriter.New(
@@ -46,19 +61,176 @@ func New(cfg *config.Config) *App {
 			cfg.DnslogMaxsize,
 			cfg.DnslogCount,
 			cfg.DnslogAge)
-		a.handlers = append(a.handlers, h)
+		if a.transaction {
+			a.txHandlers = append(a.txHandlers, handler.Filtered(h, cfg.GetDnslogFilter()))
+		} else {
+			a.handlers = append(a.handlers, handler.Filtered(h, cfg.GetDnslogFilter()))
+		}
 	}
 
 	if cfg.AnalyzeEnable {
 		h := analyzer.New(
 			path.Join(cfg.OutputDir, cfg.AnalyzeOutFilename),
 			cfg.GetAnalyeInterval(),
-			cfg.AnalyzeIps,
+			cfg.GetAnalyzeQueryCountIps(),
+			cfg.GetClientGroups(),
+			config.OtherClientGroup,
 			cfg.AnalyzeDomains,
-			cfg.SelfIps)
+			cfg.GetAnalyzeDomainGroup(),
+			classifier,
+			a.sampleRate,
+			a.txTimeout,
+			cfg.GetCacheHitLatency())
+		a.handlers = append(a.handlers, handler.Filtered(h, cfg.GetAnalyzeFilter()))
+	}
+
+	if cfg.CorrelateEnable {
+		h := correlator.New(
+			path.Join(cfg.OutputDir, cfg.CorrelateFilename),
+			classifier,
+			a.txTimeout)
+		a.handlers = append(a.handlers, h)
+	}
+
+	if cfg.CacheSimEnable {
+		h := cachesim.New(
+			path.Join(cfg.OutputDir, cfg.CacheSimFilename),
+			classifier,
+			cfg.GetCacheSimSizes(),
+			cfg.GetCacheSimPolicies(),
+			cfg.GetCacheSimMaxTtl(),
+			cfg.GetCacheSimMaxNegativeTtl())
+		a.handlers = append(a.handlers, h)
+	}
+
+	if cfg.PdnsEnable {
+		h := passivedns.New(
+			path.Join(cfg.OutputDir, cfg.PdnsDir),
+			cfg.GetPdnsFlushInterval(),
+			a.sampleRate)
+		a.handlers = append(a.handlers, h)
+	}
+
+	if cfg.IntelEnable {
+		lists := []intel.List{}
+		for _, l := range cfg.IntelLists {
+			lists = append(lists, intel.List{Name: l.Name, Format: l.Format, Path: l.Path})
+		}
+
+		h := intel.New(
+			lists,
+			path.Join(cfg.OutputDir, cfg.IntelFilename),
+			path.Join(cfg.OutputDir, cfg.IntelMetricFile),
+			cfg.GetIntelReloadInterval(),
+			cfg.GetIntelMetricInterval(),
+			a.sampleRate)
+		a.handlers = append(a.handlers, h)
+	}
+
+	if cfg.SpoofEnable {
+		h := spoofdetecter.New(
+			path.Join(cfg.OutputDir, cfg.SpoofFilename),
+			a.txTimeout,
+			cfg.SpoofTransIdBurst)
 		a.handlers = append(a.handlers, h)
 	}
 
+	if cfg.AuditEnable {
+		h := auditor.New(
+			path.Join(cfg.OutputDir, cfg.AuditFilename),
+			cfg.GetAuditInterval(),
+			cfg.GetAuditMinQueries(),
+			classifier)
+		a.handlers = append(a.handlers, h)
+	}
+
+	if cfg.RebindEnable {
+		h := rebinddetecter.New(
+			path.Join(cfg.OutputDir, cfg.RebindFilename),
+			cfg.RebindZones,
+			cfg.GetRebindWindow(),
+			classifier)
+		a.handlers = append(a.handlers, h)
+	}
+
+	if cfg.DgaEnable {
+		h := dgadetecter.New(
+			path.Join(cfg.OutputDir, cfg.DgaFilename),
+			cfg.GetDgaInterval(),
+			cfg.DgaModelList,
+			cfg.GetDgaMinNames(),
+			classifier)
+		a.handlers = append(a.handlers, h)
+	}
+
+	if cfg.AmpEnable {
+		h := ampdetecter.New(
+			path.Join(cfg.OutputDir, cfg.AmpFilename),
+			cfg.GetAmpInterval(),
+			cfg.GetAmpFactor(),
+			cfg.GetAmpMinQueries(),
+			cfg.GetAmpWindow(),
+			cfg.GetAmpMinSources(),
+			classifier)
+		a.handlers = append(a.handlers, h)
+	}
+
+	if cfg.BypassEnable {
+		endpoints := []bypassdetecter.Endpoint{}
+		if cfg.BypassDohList != "" {
+			var err error
+			if endpoints, err = bypassdetecter.LoadEndpoints(cfg.BypassDohList); err != nil {
+				logger.Fatalf("load doh endpoints failed %s", err)
+			}
+		}
+
+		h := bypassdetecter.New(
+			path.Join(cfg.OutputDir, cfg.BypassFilename),
+			cfg.GetBypassInterval(),
+			classifier,
+			cfg.GetDnsPorts(),
+			endpoints)
+		a.flowHandlers = append(a.flowHandlers, h)
+		a.bypassBpf = getBypassBpfString(cfg.GetDnsPorts(), bypassdetecter.Prefixes(endpoints))
+	}
+
+	return a
+}
+
+// newApp builds an app without any output handler, the classifier and the
+// qps writer are always set up.
+func newApp(cfg *config.Config, transaction bool) *App {
+	a := &App{
+		cfg:          cfg,
+		sessionCache: session.New(cfg.SessionCacheSize),
+		handlers:     []handler.Handler{},
+		dnsPorts:     map[uint16]struct{}{},
+		filter:       cfg.GetFilter(),
+		sampleRate:   cfg.GetSampleRate(),
+		transaction:  transaction,
+		txTimeout:    cfg.GetTransactionTimeout(),
+		closeCh:      make(chan struct{}),
+		doneCh:       make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(cfg.OutputDir, 0755); err != nil {
+		logger.Fatalf("create output dir %s failed %s", cfg.OutputDir, err)
+	}
+
+	for _, p := range cfg.GetDnsPorts() {
+		a.dnsPorts[p] = struct{}{}
+	}
+	a.startTime, a.endTime = cfg.GetTimeWindow()
+
+	if cfg.PslFilename != "" {
+		l, err := publicsuffix.Load(cfg.PslFilename)
+		if err != nil {
+			logger.Fatalf("load public suffix list %s failed %s", cfg.PslFilename, err)
+		}
+		publicsuffix.Use(l)
+	}
+
+	a.classifier = handler.NewClassifier(a.getSelfIps(), cfg.GetDnsPorts(), cfg.DnsHeuristic)
 	a.handlers = append(a.handlers, qpswriter.New())
 
 	if cfg.PprofEnable {
@@ -76,7 +248,19 @@ type App struct {
 	cfg          *config.Config
 	sessionCache *session.SessionCache
 	handlers     []handler.Handler
+	txHandlers   []handler.Handler
+	flowHandlers []handler.FlowHandler
+	bypassBpf    string
+	dnsPorts     map[uint16]struct{}
+	classifier   *handler.Classifier
+	filter       *filter.Filter
+	startTime    time.Time
+	endTime      time.Time
+	sampleRate   int
+	transaction  bool
+	txTimeout    time.Duration
 	closeCh      chan struct{}
+	doneCh       chan struct{}
 }
 
 func (a *App) Run() {
@@ -86,6 +270,21 @@ func (a *App) Run() {
 	case config.SourceTypePcapFile:
 		a.handlePcapFiles()
 	}
+
+	if a.transaction {
+		for _, v := range a.sessionCache.Purge() {
+			a.handleTransaction(types.NewTransaction(v.Query, nil))
+		}
+	}
+
+	for _, h := range append(a.handlers, a.txHandlers...) {
+		h.Stop()
+	}
+	for _, h := range a.flowHandlers {
+		h.Stop()
+	}
+	logger.Infof("all handler exited")
+	close(a.doneCh)
 }
 
 func (a *App) handlePcap() {
@@ -96,7 +295,7 @@ func (a *App) handlePcap() {
 	}
 	defer handle.Close()
 
-	bpf := getBpfFilterString(a.cfg.GetFilterIps())
+	bpf := getBpfFilterString(a.cfg.GetFilterIps(), a.cfg.GetDnsPorts(), a.cfg.DnsHeuristic, a.bypassBpf)
 	if err := handle.SetBPFFilter(bpf); err != nil {
 		logger.Fatalf("set bfp filter failed [%s] %s", bpf, err)
 		return
@@ -111,32 +310,84 @@ func (a *App) handlePcapFiles() {
 	logger.Infof("total %d pcap files need to handle", len(a.cfg.SourcePcapFiles))
 	for _, f := range a.cfg.SourcePcapFiles {
 		logger.Infof("begin handle pcap file %s", f)
-		if err := a.handleOnePacpFile(f); err != nil {
+		stopped, err := a.handleOnePacpFile(f)
+		if err != nil {
 			logger.Errorf("handle pcap file %s failed %s", f, err)
 		}
 		logger.Infof("end handle pcap file %s", f)
+		if stopped {
+			logger.Infof("skip remaining pcap files")
+			return
+		}
 	}
 }
 
-func (a *App) handleOnePacpFile(filename string) error {
-	handle, err := pcap.OpenOffline(filename)
+// handleOnePacpFile skips the packets before start time without decoding them
+// and leaves the file at the first packet after end time, the remaining files
+// are still read. It returns true when stopped by the close signal.
+func (a *App) handleOnePacpFile(filename string) (bool, error) {
+	handle, err := a.openPcapFile(filename)
 	if err != nil {
-		return fmt.Errorf("open pacp file %s failed %s", filename, err)
+		return false, err
 	}
 	defer handle.Close()
 
-	bpf := getBpfFilterString(a.cfg.GetFilterIps())
+	for {
+		select {
+		case <-a.closeCh:
+			logger.Infof("handle groutinue exiting by close signal")
+			return true, nil
+		default:
+		}
+
+		data, ci, err := handle.ReadPacketData()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if !a.startTime.IsZero() && ci.Timestamp.Before(a.startTime) {
+			continue
+		}
+		if !a.endTime.IsZero() && ci.Timestamp.After(a.endTime) {
+			logger.Infof("skip rest of pcap file %s after end time %s", filename, a.endTime)
+			return false, nil
+		}
+
+		p := gopacket.NewPacket(data, handle.LinkType(), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		m := p.Metadata()
+		m.CaptureInfo = ci
+		m.Truncated = m.Truncated || ci.CaptureLength < ci.Length
+		a.handleP(p)
+	}
+}
+
+func (a *App) openPcapFile(filename string) (*pcap.Handle, error) {
+	handle, err := pcap.OpenOffline(filename)
+	if err != nil {
+		return nil, fmt.Errorf("open pacp file %s failed %s", filename, err)
+	}
+
+	bpf := getBpfFilterString(a.cfg.GetFilterIps(), a.cfg.GetDnsPorts(), a.cfg.DnsHeuristic, a.bypassBpf)
 	if err := handle.SetBPFFilter(bpf); err != nil {
-		return fmt.Errorf("set bpf filter failed [%s] %s", bpf, err)
+		handle.Close()
+		return nil, fmt.Errorf("set bpf filter failed [%s] %s", bpf, err)
 	}
 	logger.Infof("set bpf filter succeed [%s]", bpf)
-
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-	a.handlePacketSource(packetSource)
-	return nil
+	return handle, nil
 }
 
 func (a *App) handlePacketSource(s *gopacket.PacketSource) {
+	// a quiet live capture still has to emit its unanswered transactions
+	var tick <-chan time.Time
+	if a.transaction {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
 	for {
 		select {
 		case p, ok := <-s.Packets():
@@ -145,44 +396,98 @@ func (a *App) handlePacketSource(s *gopacket.PacketSource) {
 				return
 			}
 			a.handleP(p)
+		case now := <-tick:
+			a.expireTransactions(now)
 		case <-a.closeCh:
 			logger.Infof("handle groutinue exiting by close signal")
-			a.closeCh <- struct{}{}
 			return
 		}
 	}
 }
 
+func (a *App) inTimeWindow(t time.Time) bool {
+	if !a.startTime.IsZero() && t.Before(a.startTime) {
+		return false
+	}
+	if !a.endTime.IsZero() && t.After(a.endTime) {
+		return false
+	}
+	return true
+}
+
+func (a *App) sampled(dl *types.Dnslog) bool {
+	if a.sampleRate <= 1 {
+		return true
+	}
+
+	src := append(append([]byte{}, dl.SrcIP.To16()...), byte(dl.SrcPort>>8), byte(dl.SrcPort))
+	dst := append(append([]byte{}, dl.DstIP.To16()...), byte(dl.DstPort>>8), byte(dl.DstPort))
+	if bytes.Compare(src, dst) > 0 {
+		src, dst = dst, src
+	}
+
+	h := fnv.New32a()
+	h.Write(src)
+	h.Write(dst)
+	h.Write([]byte{byte(dl.TransID >> 8), byte(dl.TransID)})
+	return h.Sum32()%uint32(a.sampleRate) == 0
+}
+
+func (a *App) expireTransactions(now time.Time) {
+	for _, v := range a.sessionCache.Expire(now.Add(-a.txTimeout)) {
+		a.handleTransaction(types.NewTransaction(v.Query, nil))
+	}
+}
+
 func (a *App) handleP(p gopacket.Packet) {
 	if p == nil {
 		return
 	}
 
-	dl, err := unpack(p)
+	if len(a.flowHandlers) > 0 {
+		a.handleFlow(p)
+	}
+
+	dl, err := a.unpack(p)
 	if err != nil {
 		logger.Debugf("unpack packet failed %s", err)
 		return
 	}
 
 	if dl.Response {
-		if err := a.matchSession(dl); err != nil {
+		query, err := a.matchSession(dl)
+		if err != nil {
 			logger.Debugf("%s", err)
 		}
+		if a.transaction {
+			a.handleTransaction(types.NewTransaction(query, dl))
+		}
 	} else {
 		a.add2Session(dl)
 	}
 
+	if a.transaction {
+		a.expireTransactions(dl.PacketTime)
+	}
+
+	if !a.filter.Match(dl) {
+		return
+	}
+
 	for _, h := range a.handlers {
 		h.Handle(dl)
 	}
 }
 
-func unpack(p gopacket.Packet) (*types.Dnslog, error) {
+func (a *App) unpack(p gopacket.Packet) (*types.Dnslog, error) {
 	dl := &types.Dnslog{}
 	if p.Metadata() == nil {
 		return nil, fmt.Errorf("packet metadata missing")
 	}
 	dl.PacketTime = p.Metadata().Timestamp
+	if !a.inTimeWindow(dl.PacketTime) {
+		return nil, fmt.Errorf("packet time %s out of time window", dl.PacketTime)
+	}
 
 	ipLayer := p.Layer(layers.LayerTypeIPv4)
 	if ipLayer != nil {
@@ -216,31 +521,163 @@ func unpack(p gopacket.Packet) (*types.Dnslog, error) {
 	dl.SrcPort = uint16(udp.SrcPort)
 	dl.DstPort = uint16(udp.DstPort)
 
+	_, srcIsDns := a.dnsPorts[dl.SrcPort]
+	_, dstIsDns := a.dnsPorts[dl.DstPort]
+	if !srcIsDns && !dstIsDns && !a.cfg.DnsHeuristic {
+		return dl, fmt.Errorf("packet not on dns ports [srcport:%d dstport:%d]", dl.SrcPort, dl.DstPort)
+	}
+
+	if len(udp.Payload) >= 2 {
+		dl.TransID = uint16(udp.Payload[0])<<8 | uint16(udp.Payload[1])
+	}
+	if !a.sampled(dl) {
+		return nil, fmt.Errorf("packet skipped by sampling")
+	}
+
 	msg := new(dns.Msg)
 	if err := msg.Unpack(udp.Payload); err != nil {
 		return dl, fmt.Errorf("packet unpack to dns msg failed %s", err)
 	}
 
+	if !srcIsDns && !dstIsDns {
+		if err := checkHeuristicMsg(msg); err != nil {
+			return dl, fmt.Errorf("packet on ports %d-%d not dns %s", dl.SrcPort, dl.DstPort, err)
+		}
+		dl.Heuristic = true
+	}
+
 	types.DnslogFromMsg(msg, dl)
+	if dl.Response {
+		dl.ResponseSize = len(udp.Payload)
+	} else {
+		dl.QuerySize = len(udp.Payload)
+	}
 	return dl, nil
 }
 
-func getBpfFilterString(ips []net.IP) string {
-	if len(ips) == 0 {
-		return "udp and port 53"
+func checkHeuristicMsg(msg *dns.Msg) error {
+	if msg.Opcode != dns.OpcodeQuery {
+		return fmt.Errorf("unexpected opcode %d", msg.Opcode)
 	}
 
-	s := ""
+	if len(msg.Question) != 1 {
+		return fmt.Errorf("unexpected question count %d", len(msg.Question))
+	}
+
+	q := msg.Question[0]
+	switch q.Qclass {
+	case dns.ClassINET, dns.ClassCHAOS, dns.ClassHESIOD, dns.ClassANY:
+	default:
+		return fmt.Errorf("unexpected qclass %d", q.Qclass)
+	}
+
+	if _, ok := dns.TypeToString[q.Qtype]; !ok {
+		return fmt.Errorf("unexpected qtype %d", q.Qtype)
+	}
+
+	if !msg.Response {
+		if msg.Rcode != dns.RcodeSuccess || len(msg.Answer) != 0 || len(msg.Ns) != 0 {
+			return errors.New("query carry rcode or records")
+		}
+		return nil
+	}
+
+	if _, ok := dns.RcodeToString[msg.Rcode]; !ok {
+		return fmt.Errorf("unexpected rcode %d", msg.Rcode)
+	}
+	return nil
+}
 
+func getBpfFilterString(prefixes []netip.Prefix, ports []uint16, heuristic bool, extra string) string {
+	s := "udp"
+	if !heuristic {
+		s += fmt.Sprintf(" and (%s)", portsBpf(ports))
+	}
+	if extra != "" {
+		s = fmt.Sprintf("(%s) or %s", s, extra)
+	}
+
+	if len(prefixes) == 0 {
+		return s
+	}
+	return fmt.Sprintf("(%s) and (%s)", hostsBpf(prefixes), s)
+}
+
+// getBypassBpfString captures dns over tcp, dns over tls or quic, and port
+// 443 of the doh endpoints.
+func getBypassBpfString(ports []uint16, dohPrefixes []netip.Prefix) string {
+	s := fmt.Sprintf("(tcp and (%s)) or port %d", portsBpf(ports), bypassdetecter.DotPort)
+	if len(dohPrefixes) > 0 {
+		s += fmt.Sprintf(" or (port %d and (%s))", bypassdetecter.DohPort, hostsBpf(dohPrefixes))
+	}
+	return "(" + s + ")"
+}
+
+func portsBpf(ports []uint16) string {
+	pss := []string{}
+	for _, p := range ports {
+		pss = append(pss, fmt.Sprintf("port %d", p))
+	}
+	return strings.Join(pss, " or ")
+}
+
+func hostsBpf(prefixes []netip.Prefix) string {
 	hss := []string{}
-	for _, ip := range ips {
-		hs := fmt.Sprintf("host %s ", ip.String())
-		hss = append(hss, hs)
+	for _, p := range iptrie.Aggregate(prefixes) {
+		if p.IsSingleIP() {
+			hss = append(hss, fmt.Sprintf("host %s", p.Addr().String()))
+		} else {
+			hss = append(hss, fmt.Sprintf("net %s", p.String()))
+		}
+	}
+	return strings.Join(hss, " or ")
+}
+
+func (a *App) handleFlow(p gopacket.Packet) {
+	f, err := unpackFlow(p)
+	if err != nil {
+		logger.Debugf("unpack flow failed %s", err)
+		return
+	}
+	if !a.inTimeWindow(f.PacketTime) {
+		return
+	}
+
+	for _, h := range a.flowHandlers {
+		h.HandleFlow(f)
+	}
+}
+
+func unpackFlow(p gopacket.Packet) (*types.Flow, error) {
+	if p.Metadata() == nil {
+		return nil, fmt.Errorf("packet metadata missing")
+	}
+	f := &types.Flow{
+		PacketTime: p.Metadata().Timestamp,
+		Length:     p.Metadata().Length,
+	}
+
+	switch ip := p.NetworkLayer().(type) {
+	case *layers.IPv4:
+		f.SrcIP, f.DstIP = ip.SrcIP, ip.DstIP
+	case *layers.IPv6:
+		f.SrcIP, f.DstIP = ip.SrcIP, ip.DstIP
+	default:
+		return nil, fmt.Errorf("packet missing ip layer")
 	}
-	s += strings.Join(hss, "or ")
 
-	s += "and udp and port 53"
-	return s
+	switch t := p.TransportLayer().(type) {
+	case *layers.UDP:
+		f.Transport = types.TransportUdp
+		f.SrcPort, f.DstPort = uint16(t.SrcPort), uint16(t.DstPort)
+	case *layers.TCP:
+		f.Transport = types.TransportTcp
+		f.SrcPort, f.DstPort = uint16(t.SrcPort), uint16(t.DstPort)
+		f.Syn = t.SYN && !t.ACK
+	default:
+		return nil, fmt.Errorf("packet missing udp or tcp layer")
+	}
+	return f, nil
 }
 
 func (a *App) add2Session(dl *types.Dnslog) {
@@ -253,16 +690,33 @@ func (a *App) add2Session(dl *types.Dnslog) {
 	}
 
 	v := session.SessionValue{
-		QueryTime: dl.PacketTime,
-		QueryType: dl.QueryType,
-		Domain:    dl.Domain,
+		QueryTime:      dl.PacketTime,
+		FirstQueryTime: dl.PacketTime,
+		QueryType:      dl.QueryType,
+		Domain:         dl.Domain,
+		Query:          dl,
+	}
+
+	if old, ok := a.sessionCache.Find(k); ok {
+		if old.QueryType == dl.QueryType && old.Domain == dl.Domain {
+			v.FirstQueryTime = old.FirstQueryTime
+			v.Retries = old.Retries + 1
+		} else if a.transaction {
+			a.handleTransaction(types.NewTransaction(old.Query, nil))
+		}
 	}
-	if a.sessionCache.Add(k, v) {
+	dl.Retries = v.Retries
+	dl.FirstQueryTime = v.FirstQueryTime
+
+	if evicted := a.sessionCache.Add(k, v); evicted != nil {
 		logger.Errorf("session cache evict occured")
+		if a.transaction {
+			a.handleTransaction(types.NewTransaction(evicted.Query, nil))
+		}
 	}
 }
 
-func (a *App) matchSession(dl *types.Dnslog) error {
+func (a *App) matchSession(dl *types.Dnslog) (*types.Dnslog, error) {
 	k := session.SessionKey{
 		SrcIP:   dl.DstIP.String(),
 		DstIP:   dl.SrcIP.String(),
@@ -273,25 +727,34 @@ func (a *App) matchSession(dl *types.Dnslog) error {
 
 	v, ok := a.sessionCache.Find(k)
 	if !ok {
-		return fmt.Errorf("match session failed [src:%s dst:%s srcport:%d dstport:%d transid:%d]", k.SrcIP, k.DstIP, k.SrcPort, k.DstPort, k.TransID)
+		return nil, fmt.Errorf("match session failed [src:%s dst:%s srcport:%d dstport:%d transid:%d]", k.SrcIP, k.DstIP, k.SrcPort, k.DstPort, k.TransID)
 	}
 
 	if dl.QueryType != v.QueryType || dl.Domain != v.Domain {
-		return fmt.Errorf("match session failed by querytype or domain not match [%s %s]", dl.QueryType, dl.Domain)
+		return nil, fmt.Errorf("match session failed by querytype or domain not match [%s %s]", dl.QueryType, dl.Domain)
 	}
 	defer a.sessionCache.Delete(k)
 
 	dl.ResolvDuration = dl.PacketTime.Sub(v.QueryTime)
-	return nil
+	dl.FirstResolvDuration = dl.PacketTime.Sub(v.FirstQueryTime)
+	dl.Retries = v.Retries
+	return v.Query, nil
 }
 
-func (a *App) Stop() {
-	a.closeCh <- struct{}{}
-	<-a.closeCh
+func (a *App) handleTransaction(tx *types.Dnslog) {
+	if !a.filter.Match(tx) {
+		return
+	}
 
-	for _, h := range a.handlers {
-		h.Stop()
+	for _, h := range a.txHandlers {
+		h.Handle(tx)
 	}
+}
 
-	logger.Infof("all handler exited")
+func (a *App) Stop() {
+	select {
+	case a.closeCh <- struct{}{}:
+	case <-a.doneCh:
+	}
+	<-a.doneCh
 }
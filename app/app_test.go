@@ -0,0 +1,81 @@
+package app
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCheckHeuristicMsg(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("www.example.com.", dns.TypeA)
+
+	response := new(dns.Msg)
+	response.SetReply(query)
+	response.Answer = append(response.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+	})
+
+	twoQuestions := query.Copy()
+	twoQuestions.Question = append(twoQuestions.Question, twoQuestions.Question[0])
+
+	notify := query.Copy()
+	notify.Opcode = dns.OpcodeNotify
+
+	badClass := query.Copy()
+	badClass.Question[0].Qclass = 1000
+
+	badType := query.Copy()
+	badType.Question[0].Qtype = 60000
+
+	queryWithAnswer := query.Copy()
+	queryWithAnswer.Answer = response.Answer
+
+	cases := []struct {
+		name string
+		msg  *dns.Msg
+		ok   bool
+	}{
+		{"query", query, true},
+		{"response", response, true},
+		{"no question", new(dns.Msg), false},
+		{"two questions", twoQuestions, false},
+		{"notify", notify, false},
+		{"bad class", badClass, false},
+		{"bad type", badType, false},
+		{"query with answer", queryWithAnswer, false},
+	}
+	for _, c := range cases {
+		if err := checkHeuristicMsg(c.msg); (err == nil) != c.ok {
+			t.Fatalf("check %s got %v want ok %v", c.name, err, c.ok)
+		}
+	}
+}
+
+func TestGetBpfFilterString(t *testing.T) {
+	prefixes := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.53/32"),
+		netip.MustParsePrefix("192.168.0.0/24"),
+	}
+
+	cases := []struct {
+		prefixes  []netip.Prefix
+		ports     []uint16
+		heuristic bool
+		extra     string
+		want      string
+	}{
+		{nil, []uint16{53}, false, "", "udp and (port 53)"},
+		{nil, []uint16{53, 5353}, false, "", "udp and (port 53 or port 5353)"},
+		{nil, []uint16{53}, true, "", "udp"},
+		{prefixes, []uint16{53}, false, "", "(host 10.0.0.53 or net 192.168.0.0/24) and (udp and (port 53))"},
+		{nil, []uint16{53}, false, "(port 853)", "(udp and (port 53)) or (port 853)"},
+		{prefixes, []uint16{53}, true, "(port 853)", "(host 10.0.0.53 or net 192.168.0.0/24) and ((udp) or (port 853))"},
+	}
+	for _, c := range cases {
+		if s := getBpfFilterString(c.prefixes, c.ports, c.heuristic, c.extra); s != c.want {
+			t.Fatalf("bpf got %q want %q", s, c.want)
+		}
+	}
+}
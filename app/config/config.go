@@ -4,12 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"net"
+	"net/netip"
 	"os"
 	"time"
 
 	"github.com/miekg/dns"
 	"gopkg.in/yaml.v2"
+
+	"github.com/hiwyw/dnscap-go/app/filter"
+	"github.com/hiwyw/dnscap-go/app/pkg/domaintrie"
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+	"github.com/hiwyw/dnscap-go/app/pkg/publicsuffix"
 )
 
 func Load(fp string) *Config {
@@ -34,6 +39,10 @@ func Load(fp string) *Config {
 	}
 	c.AnalyzeDomains = domains
 
+	if len(c.DnsPorts) == 0 {
+		c.DnsPorts = []int{defaultDnsPort}
+	}
+
 	return c
 }
 
@@ -45,18 +54,25 @@ func Generate(fp string) {
 			"dns.pcap01",
 			"dns.pcap02",
 		},
-		FilterIps: []string{},
-		OutputDir: "./dnscap_result",
+		StartTime:  "",
+		EndTime:    "",
+		SampleRate: 1,
+		FilterIps:  []string{},
+		OutputDir:  "./dnscap_result",
 		SelfIps: []string{
 			"192.168.134.200",
 			"192.168.135.200",
 		},
+		SelfIpsAuto:        false,
+		SelfIpsAutoPackets: defaultSelfIpsAutoPackets,
 		SessionCacheSize:   100000,
 		DnslogEnable:       true,
 		DnslogFilename:     "dns.log",
 		DnslogMaxsize:      50,
 		DnslogCount:        100,
 		DnslogAge:          30,
+		DnslogMode:         DnslogModePacket,
+		TransactionTimeout: "5s",
 		AnalyzeEnable:      false,
 		AnalyzeOutFilename: "analyze.log",
 		AnalyzeInterval:    "5m",
@@ -67,8 +83,66 @@ func Generate(fp string) {
 		AnalyzeDomains: []string{
 			"www.test.com.",
 		},
-		PprofEnable:   false,
-		PprofHttpPort: 8000,
+		AnalyzeDomainGroup: "",
+		ClientGroups: map[string][]string{
+			"office": {"192.168.0.0/16"},
+		},
+		ClientGroupsFile:  "",
+		CacheHitLatency:   "5ms",
+		DnsPorts:          []int{53},
+		DnsHeuristic:      false,
+		Filter:            "",
+		DnslogFilter:      "",
+		AnalyzeFilter:     "",
+		CorrelateEnable:   false,
+		CorrelateFilename: "correlate.log",
+		CacheSimEnable:    false,
+		CacheSimFilename:  "cachesim.json",
+		CacheSimSizes:     []int{10000, 100000, 1000000},
+		CacheSimPolicies:  []string{"lru", "lfu", "fifo"},
+		CacheSimMaxTtl:    "24h",
+		CacheSimMaxNegTtl: "3h",
+		PdnsEnable:        false,
+		PdnsDir:           "pdns",
+		PdnsFlushInterval: "1m",
+		IntelEnable:       false,
+		IntelFilename:     "intel_alert.log",
+		IntelMetricFile:   "intel_metric.log",
+		IntelReload:       "30s",
+		IntelMetricPeriod: "1m",
+		IntelLists: []IntelList{
+			{Name: "malware", Format: "domain", Path: "malware_domains.txt"},
+		},
+		SpoofEnable:       false,
+		SpoofFilename:     "security.log",
+		SpoofTransIdBurst: 3,
+		AuditEnable:       false,
+		AuditFilename:     "audit.log",
+		AuditInterval:     "5m",
+		AuditMinQueries:   100,
+		RebindEnable:      false,
+		RebindFilename:    "rebinding.log",
+		RebindWindow:      "1m",
+		RebindZones:       []string{"corp.example.com"},
+		BypassEnable:      false,
+		BypassFilename:    "bypass.log",
+		BypassInterval:    "5m",
+		BypassDohList:     "",
+		DgaEnable:         false,
+		DgaFilename:       "dga.log",
+		DgaInterval:       "5m",
+		DgaModelList:      "",
+		DgaMinNames:       10,
+		AmpEnable:         false,
+		AmpFilename:       "amplification.log",
+		AmpInterval:       "5m",
+		AmpFactor:         10,
+		AmpMinQueries:     100,
+		AmpWindow:         "10s",
+		AmpMinSources:     50,
+		PslFilename:       "",
+		PprofEnable:       false,
+		PprofHttpPort:     8000,
 	}
 
 	content, err := yaml.Marshal(c)
@@ -82,6 +156,35 @@ func Generate(fp string) {
 	log.Printf("config file %s generated", fp)
 }
 
+const (
+	defaultDnsPort            = 53
+	defaultSelfIpsAutoPackets = 1000000
+	timeLayout                = "2006-01-02 15:04:05"
+	defaultTransactionTimeout = 5 * time.Second
+	defaultCacheHitLatency    = 5 * time.Millisecond
+	defaultCacheSimMaxTtl     = 24 * time.Hour
+	defaultCacheSimMaxNegTtl  = 3 * time.Hour
+	defaultPdnsFlushInterval  = time.Minute
+	defaultIntelReload        = 30 * time.Second
+	defaultIntelMetricPeriod  = time.Minute
+	defaultAuditInterval      = 5 * time.Minute
+	defaultAuditMinQueries    = 100
+	defaultRebindWindow       = time.Minute
+	defaultBypassInterval     = 5 * time.Minute
+	defaultDgaInterval        = 5 * time.Minute
+	defaultDgaMinNames        = 10
+	defaultAmpInterval        = 5 * time.Minute
+	defaultAmpFactor          = 10
+	defaultAmpMinQueries      = 100
+	defaultAmpWindow          = 10 * time.Second
+	defaultAmpMinSources      = 50
+)
+
+var (
+	defaultCacheSimSizes    = []int{10000, 100000, 1000000}
+	defaultCacheSimPolicies = []string{"lru", "lfu", "fifo"}
+)
+
 type InputSourceType string
 
 const (
@@ -89,26 +192,132 @@ const (
 	SourceTypePcap     InputSourceType = "packet_capture"
 )
 
+type IntelList struct {
+	Name   string `yaml:"name"`
+	Format string `yaml:"format"`
+	Path   string `yaml:"path"`
+}
+
+// OtherClientGroup is the analyze group of the clients not in any client group.
+const OtherClientGroup = "other"
+
+type DnslogMode string
+
+const (
+	DnslogModePacket      DnslogMode = "packet"
+	DnslogModeTransaction DnslogMode = "transaction"
+)
+
 type Config struct {
-	SourceType         InputSourceType `yaml:"source_type"`
-	SourcePcapFiles    []string        `yaml:"source_pcap_files"`
-	SourceDeviceName   string          `yaml:"source_device_name"`
-	FilterIps          []string        `yaml:"filter_ips"`
-	OutputDir          string          `yaml:"output_dir"`
-	SelfIps            []string        `yaml:"self_ips"`
-	SessionCacheSize   int             `yaml:"session_cache_size"`
-	DnslogEnable       bool            `yaml:"dnslog_enable"`
-	DnslogFilename     string          `yaml:"dnslog_filename"`
-	DnslogMaxsize      int             `yaml:"dnslog_maxsize"`
-	DnslogCount        int             `yaml:"dnslog_count"`
-	DnslogAge          int             `yaml:"dnslog_age"`
-	AnalyzeEnable      bool            `yaml:"analyze_enable"`
-	AnalyzeOutFilename string          `yaml:"analyzeOutFilename"`
-	AnalyzeInterval    string          `yaml:"analyze_interval"`
-	AnalyzeIps         []string        `yaml:"analyze_querycount_ips"`
-	AnalyzeDomains     []string        `yaml:"analyze_querycount_domains"`
-	PprofEnable        bool            `yaml:"pprof_enable"`
-	PprofHttpPort      int             `yaml:"pprof_http_port"`
+	SourceType         InputSourceType     `yaml:"source_type"`
+	SourcePcapFiles    []string            `yaml:"source_pcap_files"`
+	SourceDeviceName   string              `yaml:"source_device_name"`
+	StartTime          string              `yaml:"start_time"`
+	EndTime            string              `yaml:"end_time"`
+	SampleRate         int                 `yaml:"sample_rate"`
+	FilterIps          []string            `yaml:"filter_ips"`
+	OutputDir          string              `yaml:"output_dir"`
+	SelfIps            []string            `yaml:"self_ips"`
+	SelfIpsAuto        bool                `yaml:"self_ips_auto"`
+	SelfIpsAutoPackets int                 `yaml:"self_ips_auto_packets"`
+	SessionCacheSize   int                 `yaml:"session_cache_size"`
+	DnslogEnable       bool                `yaml:"dnslog_enable"`
+	DnslogFilename     string              `yaml:"dnslog_filename"`
+	DnslogMaxsize      int                 `yaml:"dnslog_maxsize"`
+	DnslogCount        int                 `yaml:"dnslog_count"`
+	DnslogAge          int                 `yaml:"dnslog_age"`
+	DnslogMode         DnslogMode          `yaml:"dnslog_mode"`
+	TransactionTimeout string              `yaml:"transaction_timeout"`
+	AnalyzeEnable      bool                `yaml:"analyze_enable"`
+	AnalyzeOutFilename string              `yaml:"analyzeOutFilename"`
+	AnalyzeInterval    string              `yaml:"analyze_interval"`
+	AnalyzeIps         []string            `yaml:"analyze_querycount_ips"`
+	AnalyzeDomains     []string            `yaml:"analyze_querycount_domains"`
+	AnalyzeDomainGroup string              `yaml:"analyze_domain_group"`
+	ClientGroups       map[string][]string `yaml:"client_groups"`
+	ClientGroupsFile   string              `yaml:"client_groups_file"`
+	CacheHitLatency    string              `yaml:"analyze_cache_hit_latency"`
+	DnsPorts           []int               `yaml:"dns_ports"`
+	DnsHeuristic       bool                `yaml:"dns_heuristic"`
+	Filter             string              `yaml:"filter"`
+	DnslogFilter       string              `yaml:"dnslog_filter"`
+	AnalyzeFilter      string              `yaml:"analyze_filter"`
+	CorrelateEnable    bool                `yaml:"correlate_enable"`
+	CorrelateFilename  string              `yaml:"correlate_filename"`
+	CacheSimEnable     bool                `yaml:"cachesim_enable"`
+	CacheSimFilename   string              `yaml:"cachesim_filename"`
+	CacheSimSizes      []int               `yaml:"cachesim_sizes"`
+	CacheSimPolicies   []string            `yaml:"cachesim_policies"`
+	CacheSimMaxTtl     string              `yaml:"cachesim_max_ttl"`
+	CacheSimMaxNegTtl  string              `yaml:"cachesim_max_negative_ttl"`
+	PdnsEnable         bool                `yaml:"pdns_enable"`
+	PdnsDir            string              `yaml:"pdns_dir"`
+	PdnsFlushInterval  string              `yaml:"pdns_flush_interval"`
+	IntelEnable        bool                `yaml:"intel_enable"`
+	IntelFilename      string              `yaml:"intel_filename"`
+	IntelMetricFile    string              `yaml:"intel_metric_filename"`
+	IntelReload        string              `yaml:"intel_reload_interval"`
+	IntelMetricPeriod  string              `yaml:"intel_metric_interval"`
+	IntelLists         []IntelList         `yaml:"intel_lists"`
+	SpoofEnable        bool                `yaml:"spoof_enable"`
+	SpoofFilename      string              `yaml:"spoof_filename"`
+	SpoofTransIdBurst  int                 `yaml:"spoof_transid_burst"`
+	AuditEnable        bool                `yaml:"audit_enable"`
+	AuditFilename      string              `yaml:"audit_filename"`
+	AuditInterval      string              `yaml:"audit_interval"`
+	AuditMinQueries    int                 `yaml:"audit_min_queries"`
+	RebindEnable       bool                `yaml:"rebind_enable"`
+	RebindFilename     string              `yaml:"rebind_filename"`
+	RebindWindow       string              `yaml:"rebind_window"`
+	RebindZones        []string            `yaml:"rebind_internal_zones"`
+	BypassEnable       bool                `yaml:"bypass_enable"`
+	BypassFilename     string              `yaml:"bypass_filename"`
+	BypassInterval     string              `yaml:"bypass_interval"`
+	BypassDohList      string              `yaml:"bypass_doh_list"`
+	DgaEnable          bool                `yaml:"dga_enable"`
+	DgaFilename        string              `yaml:"dga_filename"`
+	DgaInterval        string              `yaml:"dga_interval"`
+	DgaModelList       string              `yaml:"dga_model_list"`
+	DgaMinNames        int                 `yaml:"dga_min_names"`
+	AmpEnable          bool                `yaml:"amp_enable"`
+	AmpFilename        string              `yaml:"amp_filename"`
+	AmpInterval        string              `yaml:"amp_interval"`
+	AmpFactor          float64             `yaml:"amp_factor"`
+	AmpMinQueries      int                 `yaml:"amp_min_queries"`
+	AmpWindow          string              `yaml:"amp_window"`
+	AmpMinSources      int                 `yaml:"amp_min_sources"`
+	PslFilename        string              `yaml:"psl_filename"`
+	PprofEnable        bool                `yaml:"pprof_enable"`
+	PprofHttpPort      int                 `yaml:"pprof_http_port"`
+}
+
+// enabledOutputs returns the names of the enabled output handlers.
+func (c *Config) enabledOutputs() []string {
+	outputs := []struct {
+		name   string
+		enable bool
+	}{
+		{"dnslog", c.DnslogEnable},
+		{"analyze", c.AnalyzeEnable},
+		{"correlate", c.CorrelateEnable},
+		{"cachesim", c.CacheSimEnable},
+		{"pdns", c.PdnsEnable},
+		{"intel", c.IntelEnable},
+		{"spoof", c.SpoofEnable},
+		{"audit", c.AuditEnable},
+		{"rebind", c.RebindEnable},
+		{"bypass", c.BypassEnable},
+		{"dga", c.DgaEnable},
+		{"amp", c.AmpEnable},
+	}
+
+	enabled := []string{}
+	for _, o := range outputs {
+		if o.enable {
+			enabled = append(enabled, o.name)
+		}
+	}
+	return enabled
 }
 
 func (c *Config) Validate() error {
@@ -126,48 +335,413 @@ func (c *Config) Validate() error {
 		return errors.New("source device name empty")
 	}
 
-	if !c.DnslogEnable && !c.AnalyzeEnable {
-		return errors.New("both dnslog and analyze disabled")
+	if len(c.enabledOutputs()) == 0 {
+		return errors.New("all outputs disabled")
 	}
 
 	for _, d := range c.AnalyzeDomains {
-		if _, ok := dns.IsDomainName(d); !ok {
-			if !ok {
-				return fmt.Errorf("%d not domain name", d)
-			}
+		if _, _, err := domaintrie.Parse(d); err != nil {
+			return err
+		}
+	}
+
+	switch c.DnslogMode {
+	case "", DnslogModePacket, DnslogModeTransaction:
+	default:
+		return fmt.Errorf("unknown dnslog mode %s", c.DnslogMode)
+	}
+
+	if c.SampleRate < 0 {
+		return fmt.Errorf("invalid sample rate %d", c.SampleRate)
+	}
+
+	// client transactions and their upstream queries are sampled apart
+	if c.SampleRate > 1 && (c.CorrelateEnable || c.CacheSimEnable) {
+		return fmt.Errorf("correlate and cachesim not supported with sample rate %d", c.SampleRate)
+	}
+
+	// a spoofed response has its own source or transid and is sampled apart
+	// from the query it targets
+	if c.SampleRate > 1 && c.SpoofEnable {
+		return fmt.Errorf("spoof not supported with sample rate %d", c.SampleRate)
+	}
+
+	// the transid and port sequence checks compare consecutive queries, which
+	// sampling takes apart
+	if c.SampleRate > 1 && c.AuditEnable {
+		return fmt.Errorf("audit not supported with sample rate %d", c.SampleRate)
+	}
+
+	start, end := c.GetTimeWindow()
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		return fmt.Errorf("end time %s not after start time %s", c.EndTime, c.StartTime)
+	}
+
+	for _, p := range c.DnsPorts {
+		if p <= 0 || p > 65535 {
+			return fmt.Errorf("invalid dns port %d", p)
+		}
+	}
+
+	for _, size := range c.CacheSimSizes {
+		if size <= 0 {
+			return fmt.Errorf("invalid cachesim size %d", size)
+		}
+	}
+
+	for _, p := range c.CacheSimPolicies {
+		switch p {
+		case "lru", "lfu", "fifo":
+		default:
+			return fmt.Errorf("unknown cachesim policy %s", p)
+		}
+	}
+
+	names := map[string]struct{}{}
+	for _, l := range c.IntelLists {
+		if l.Name == "" || l.Path == "" {
+			return fmt.Errorf("intel list name or path empty")
+		}
+		if _, ok := names[l.Name]; ok {
+			return fmt.Errorf("duplicate intel list name %s", l.Name)
+		}
+		names[l.Name] = struct{}{}
+
+		switch l.Format {
+		case "", "domain", "ip", "rpz":
+		default:
+			return fmt.Errorf("unknown intel list format %s", l.Format)
 		}
 	}
+	if c.IntelEnable && len(c.IntelLists) == 0 {
+		return errors.New("intel enabled without intel lists")
+	}
+
+	if c.SpoofTransIdBurst < 0 {
+		return fmt.Errorf("invalid spoof transid burst %d", c.SpoofTransIdBurst)
+	}
+
+	if c.AuditMinQueries < 0 {
+		return fmt.Errorf("invalid audit min queries %d", c.AuditMinQueries)
+	}
+
+	if c.AmpFactor < 0 || c.AmpMinQueries < 0 || c.AmpMinSources < 0 {
+		return fmt.Errorf("invalid amp factor %v min queries %d or min sources %d", c.AmpFactor, c.AmpMinQueries, c.AmpMinSources)
+	}
+
+	if c.DgaMinNames < 0 {
+		return fmt.Errorf("invalid dga min names %d", c.DgaMinNames)
+	}
+
+	for _, z := range c.RebindZones {
+		if _, ok := dns.IsDomainName(z); !ok {
+			return fmt.Errorf("rebind internal zone %s not domain name", z)
+		}
+	}
+
+	for _, f := range []string{c.Filter, c.DnslogFilter, c.AnalyzeFilter} {
+		if _, err := filter.Compile(f); err != nil {
+			return err
+		}
+	}
+
+	for _, ips := range [][]string{c.FilterIps, c.SelfIps, c.AnalyzeIps} {
+		if _, err := strings2Prefixes(ips); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.clientGroups(); err != nil {
+		return err
+	}
 
-	_ = c.GetFilterIps()
-	_ = c.GetAnalyzeQueryCountIps()
-	_ = c.GetSelfIps()
 	_ = c.GetAnalyeInterval()
+	_ = c.GetTransactionTimeout()
+	_ = c.GetCacheHitLatency()
+	_ = c.GetCacheSimMaxTtl()
+	_ = c.GetCacheSimMaxNegativeTtl()
+	_ = c.GetPdnsFlushInterval()
+	_ = c.GetIntelReloadInterval()
+	_ = c.GetIntelMetricInterval()
+	_ = c.GetAuditInterval()
+	_ = c.GetRebindWindow()
+	_ = c.GetBypassInterval()
+	_ = c.GetDgaInterval()
+	_ = c.GetAmpInterval()
+	_ = c.GetAmpWindow()
+	_ = c.GetAnalyzeDomainGroup()
 
 	return nil
 }
 
-func (c *Config) GetFilterIps() []net.IP {
-	return strings2Ips(c.FilterIps)
+func (c *Config) GetFilterIps() []netip.Prefix {
+	return mustPrefixes(c.FilterIps)
+}
+
+func (c *Config) GetSelfIps() []netip.Prefix {
+	return mustPrefixes(c.SelfIps)
+}
+
+func (c *Config) GetTransactionTimeout() time.Duration {
+	if c.TransactionTimeout == "" {
+		return defaultTransactionTimeout
+	}
+
+	d, err := time.ParseDuration(c.TransactionTimeout)
+	if err != nil || d <= 0 {
+		log.Fatalf("parse transaction timeout failed %s", c.TransactionTimeout)
+	}
+	return d
+}
+
+func (c *Config) GetCacheHitLatency() time.Duration {
+	if c.CacheHitLatency == "" {
+		return defaultCacheHitLatency
+	}
+
+	d, err := time.ParseDuration(c.CacheHitLatency)
+	if err != nil {
+		log.Fatalf("parse analyze cache hit latency failed %s", c.CacheHitLatency)
+	}
+	return d
+}
+
+func (c *Config) GetCacheSimSizes() []int {
+	if len(c.CacheSimSizes) == 0 {
+		return defaultCacheSimSizes
+	}
+	return c.CacheSimSizes
+}
+
+func (c *Config) GetCacheSimPolicies() []string {
+	if len(c.CacheSimPolicies) == 0 {
+		return defaultCacheSimPolicies
+	}
+	return c.CacheSimPolicies
+}
+
+func (c *Config) GetCacheSimMaxTtl() time.Duration {
+	return parseInterval(c.CacheSimMaxTtl, defaultCacheSimMaxTtl, "cachesim max ttl")
+}
+
+func (c *Config) GetCacheSimMaxNegativeTtl() time.Duration {
+	return parseInterval(c.CacheSimMaxNegTtl, defaultCacheSimMaxNegTtl, "cachesim max negative ttl")
+}
+
+func (c *Config) GetPdnsFlushInterval() time.Duration {
+	return parseInterval(c.PdnsFlushInterval, defaultPdnsFlushInterval, "pdns flush interval")
+}
+
+func (c *Config) GetIntelReloadInterval() time.Duration {
+	return parseInterval(c.IntelReload, defaultIntelReload, "intel reload interval")
+}
+
+func (c *Config) GetIntelMetricInterval() time.Duration {
+	return parseInterval(c.IntelMetricPeriod, defaultIntelMetricPeriod, "intel metric interval")
+}
+
+func (c *Config) GetAuditInterval() time.Duration {
+	return parseInterval(c.AuditInterval, defaultAuditInterval, "audit interval")
+}
+
+func (c *Config) GetRebindWindow() time.Duration {
+	return parseInterval(c.RebindWindow, defaultRebindWindow, "rebind window")
+}
+
+func (c *Config) GetBypassInterval() time.Duration {
+	return parseInterval(c.BypassInterval, defaultBypassInterval, "bypass interval")
+}
+
+func (c *Config) GetDgaInterval() time.Duration {
+	return parseInterval(c.DgaInterval, defaultDgaInterval, "dga interval")
+}
+
+func (c *Config) GetDgaMinNames() int {
+	if c.DgaMinNames == 0 {
+		return defaultDgaMinNames
+	}
+	return c.DgaMinNames
+}
+
+func (c *Config) GetAmpInterval() time.Duration {
+	return parseInterval(c.AmpInterval, defaultAmpInterval, "amp interval")
+}
+
+func (c *Config) GetAmpWindow() time.Duration {
+	return parseInterval(c.AmpWindow, defaultAmpWindow, "amp window")
+}
+
+func (c *Config) GetAmpFactor() float64 {
+	if c.AmpFactor == 0 {
+		return defaultAmpFactor
+	}
+	return c.AmpFactor
+}
+
+func (c *Config) GetAmpMinQueries() int {
+	if c.AmpMinQueries == 0 {
+		return defaultAmpMinQueries
+	}
+	return c.AmpMinQueries
+}
+
+func (c *Config) GetAmpMinSources() int {
+	if c.AmpMinSources == 0 {
+		return defaultAmpMinSources
+	}
+	return c.AmpMinSources
+}
+
+func (c *Config) GetAuditMinQueries() int {
+	if c.AuditMinQueries == 0 {
+		return defaultAuditMinQueries
+	}
+	return c.AuditMinQueries
+}
+
+func parseInterval(s string, def time.Duration, name string) time.Duration {
+	if s == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		log.Fatalf("parse %s failed %s", name, s)
+	}
+	return d
+}
+
+func (c *Config) GetTimeWindow() (time.Time, time.Time) {
+	return ParseTime(c.StartTime), ParseTime(c.EndTime)
+}
+
+func (c *Config) GetSampleRate() int {
+	if c.SampleRate <= 1 {
+		return 1
+	}
+	return c.SampleRate
+}
+
+func ParseTime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t
+	}
+
+	t, err := time.ParseInLocation(timeLayout, s, time.Local)
+	if err != nil {
+		log.Fatalf("parse time %s failed, use format %s or rfc3339", s, timeLayout)
+	}
+	return t
+}
+
+func (c *Config) GetSelfIpsAutoPackets() int {
+	if c.SelfIpsAutoPackets <= 0 {
+		return defaultSelfIpsAutoPackets
+	}
+	return c.SelfIpsAutoPackets
+}
+
+func (c *Config) GetAnalyzeQueryCountIps() map[string][]netip.Prefix {
+	groups := map[string][]netip.Prefix{}
+	for _, i := range c.AnalyzeIps {
+		groups[i] = append(groups[i], mustPrefixes([]string{i})...)
+	}
+	return groups
+}
+
+func (c *Config) GetAnalyzeDomainGroup() publicsuffix.Group {
+	g, err := publicsuffix.ParseGroup(c.AnalyzeDomainGroup)
+	if err != nil {
+		log.Fatalf("parse analyze domain group failed %s", err)
+	}
+	return g
+}
+
+func (c *Config) GetClientGroups() map[string][]netip.Prefix {
+	groups, err := c.clientGroups()
+	if err != nil {
+		log.Fatalf("load client groups failed %s", err)
+	}
+	return groups
 }
 
-func (c *Config) GetSelfIps() []net.IP {
-	return strings2Ips(c.SelfIps)
+// clientGroups merges the groups of client_groups and client_groups_file.
+func (c *Config) clientGroups() (map[string][]netip.Prefix, error) {
+	groups := map[string][]netip.Prefix{}
+	for name, ips := range c.ClientGroups {
+		prefixes, err := strings2Prefixes(ips)
+		if err != nil {
+			return nil, fmt.Errorf("client group %s %s", name, err)
+		}
+		groups[name] = append(groups[name], prefixes...)
+	}
+
+	if c.ClientGroupsFile != "" {
+		fileGroups, err := iptrie.LoadGroups(c.ClientGroupsFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client groups file %s failed %s", c.ClientGroupsFile, err)
+		}
+		for name, prefixes := range fileGroups {
+			groups[name] = append(groups[name], prefixes...)
+		}
+	}
+
+	if _, ok := groups[OtherClientGroup]; ok {
+		return nil, fmt.Errorf("client group name %s reserved", OtherClientGroup)
+	}
+	return groups, nil
+}
+
+func (c *Config) GetFilter() *filter.Filter {
+	return compileFilter(c.Filter)
+}
+
+func (c *Config) GetDnslogFilter() *filter.Filter {
+	return compileFilter(c.DnslogFilter)
 }
 
-func (c *Config) GetAnalyzeQueryCountIps() []net.IP {
-	return strings2Ips(c.AnalyzeIps)
+func (c *Config) GetAnalyzeFilter() *filter.Filter {
+	return compileFilter(c.AnalyzeFilter)
 }
 
-func strings2Ips(input []string) []net.IP {
-	ips := []net.IP{}
+func compileFilter(expr string) *filter.Filter {
+	f, err := filter.Compile(expr)
+	if err != nil {
+		log.Fatalf("compile filter failed %s", err)
+	}
+	return f
+}
+
+func (c *Config) GetDnsPorts() []uint16 {
+	ports := []uint16{}
+	for _, p := range c.DnsPorts {
+		ports = append(ports, uint16(p))
+	}
+	return ports
+}
+
+func strings2Prefixes(input []string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
 	for _, i := range input {
-		ip := net.ParseIP(i)
-		if ip == nil {
-			log.Fatalf("parse ip failed %s", i)
+		ps, err := iptrie.Parse(i)
+		if err != nil {
+			return nil, fmt.Errorf("parse ip failed %s", err)
 		}
-		ips = append(ips, ip)
+		prefixes = append(prefixes, ps...)
+	}
+	return prefixes, nil
+}
+
+func mustPrefixes(input []string) []netip.Prefix {
+	prefixes, err := strings2Prefixes(input)
+	if err != nil {
+		log.Fatalf("%s", err)
 	}
-	return ips
+	return prefixes
 }
 
 func (c *Config) GetAnalyeInterval() time.Duration {
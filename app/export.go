@@ -0,0 +1,21 @@
+package app
+
+import (
+	"github.com/hiwyw/dnscap-go/app/config"
+	"github.com/hiwyw/dnscap-go/app/handler/exporter"
+	"github.com/hiwyw/dnscap-go/app/logger"
+)
+
+// NewExport builds an app which only exports the captured client queries as
+// dnsperf and resperf input files, the outputs enabled in the config are
+// ignored.
+func NewExport(cfg *config.Config, opt exporter.Option) *App {
+	a := newApp(cfg, false)
+	opt.Weight = a.sampleRate
+	h, err := exporter.New(opt, a.classifier)
+	if err != nil {
+		logger.Fatalf("create exporter failed %s", err)
+	}
+	a.handlers = append(a.handlers, h)
+	return a
+}
@@ -0,0 +1,78 @@
+package filter
+
+import (
+	"net"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindNumber
+	kindDuration
+	kindIP
+	kindBool
+)
+
+func (k fieldKind) String() string {
+	switch k {
+	case kindString:
+		return "string"
+	case kindNumber:
+		return "number"
+	case kindDuration:
+		return "duration"
+	case kindIP:
+		return "ip"
+	default:
+		return "bool"
+	}
+}
+
+type field struct {
+	kind    fieldKind
+	str     func(dl *types.Dnslog) string
+	num     func(dl *types.Dnslog) int64
+	ips     func(dl *types.Dnslog) []net.IP
+	boolean func(dl *types.Dnslog) bool
+}
+
+var fields = map[string]field{
+	"qname":     {kind: kindString, str: func(dl *types.Dnslog) string { return dl.Domain }},
+	"regdomain": {kind: kindString, str: func(dl *types.Dnslog) string { return dl.RegisteredDomain }},
+	"qtype":     {kind: kindString, str: func(dl *types.Dnslog) string { return dl.QueryType }},
+	"qclass":    {kind: kindString, str: func(dl *types.Dnslog) string { return dl.QueryClass }},
+	"rcode":     {kind: kindString, str: func(dl *types.Dnslog) string { return dl.Rcode }},
+	"type": {kind: kindString, str: func(dl *types.Dnslog) string {
+		if dl.Status != "" {
+			return dl.Status
+		}
+		if dl.Response {
+			return "response"
+		}
+		return "query"
+	}},
+	"latency":  {kind: kindDuration, num: func(dl *types.Dnslog) int64 { return int64(dl.ResolvDuration) }},
+	"sport":    {kind: kindNumber, num: func(dl *types.Dnslog) int64 { return int64(dl.SrcPort) }},
+	"dport":    {kind: kindNumber, num: func(dl *types.Dnslog) int64 { return int64(dl.DstPort) }},
+	"retries":  {kind: kindNumber, num: func(dl *types.Dnslog) int64 { return int64(dl.Retries) }},
+	"transid":  {kind: kindNumber, num: func(dl *types.Dnslog) int64 { return int64(dl.TransID) }},
+	"src":      {kind: kindIP, ips: func(dl *types.Dnslog) []net.IP { return []net.IP{dl.SrcIP} }},
+	"dst":      {kind: kindIP, ips: func(dl *types.Dnslog) []net.IP { return []net.IP{dl.DstIP} }},
+	"ip":       {kind: kindIP, ips: func(dl *types.Dnslog) []net.IP { return []net.IP{dl.SrcIP, dl.DstIP} }},
+	"response": {kind: kindBool, boolean: func(dl *types.Dnslog) bool { return dl.Response }},
+	"aa":       {kind: kindBool, boolean: func(dl *types.Dnslog) bool { return dl.Authoritative }},
+	"tc":       {kind: kindBool, boolean: func(dl *types.Dnslog) bool { return dl.Truncated }},
+	"rd":       {kind: kindBool, boolean: func(dl *types.Dnslog) bool { return dl.RecursionDesired }},
+	"ra":       {kind: kindBool, boolean: func(dl *types.Dnslog) bool { return dl.RecursionAvailable }},
+}
+
+var fieldAliases = map[string]string{
+	"domain":  "qname",
+	"srcip":   "src",
+	"dstip":   "dst",
+	"srcport": "sport",
+	"dstport": "dport",
+}
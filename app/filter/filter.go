@@ -0,0 +1,424 @@
+package filter
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+// Filter is a compiled post-decode expression such as
+// `qname ~ "*.corp.example." and rcode != NOERROR and latency > 100ms`.
+// A nil Filter matches every dnslog.
+type Filter struct {
+	expr string
+	root node
+}
+
+func Compile(expr string) (*Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, fmt.Errorf("filter %q %s", expr, err)
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.peek().typ != tokenEOF {
+		err = fmt.Errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("filter %q %s", expr, err)
+	}
+
+	return &Filter{expr: expr, root: root}, nil
+}
+
+func (f *Filter) Match(dl *types.Dnslog) bool {
+	if f == nil {
+		return true
+	}
+	return f.root.eval(dl)
+}
+
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.expr
+}
+
+type node interface {
+	eval(dl *types.Dnslog) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n *andNode) eval(dl *types.Dnslog) bool { return n.left.eval(dl) && n.right.eval(dl) }
+
+type orNode struct{ left, right node }
+
+func (n *orNode) eval(dl *types.Dnslog) bool { return n.left.eval(dl) || n.right.eval(dl) }
+
+type notNode struct{ inner node }
+
+func (n *notNode) eval(dl *types.Dnslog) bool { return !n.inner.eval(dl) }
+
+type boolNode struct {
+	f    field
+	want bool
+}
+
+func (n *boolNode) eval(dl *types.Dnslog) bool { return n.f.boolean(dl) == n.want }
+
+type stringNode struct {
+	f       field
+	op      string
+	values  []string
+	pattern bool
+}
+
+func (n *stringNode) eval(dl *types.Dnslog) bool {
+	v := n.f.str(dl)
+	matched := false
+	for _, want := range n.values {
+		if n.pattern {
+			matched = globMatch(want, strings.ToLower(v))
+		} else {
+			matched = strings.EqualFold(v, want)
+		}
+		if matched {
+			break
+		}
+	}
+	if n.op == "!=" || n.op == "!~" {
+		return !matched
+	}
+	return matched
+}
+
+type numberNode struct {
+	f      field
+	op     string
+	values []int64
+}
+
+func (n *numberNode) eval(dl *types.Dnslog) bool {
+	v := n.f.num(dl)
+	switch n.op {
+	case "<":
+		return v < n.values[0]
+	case "<=":
+		return v <= n.values[0]
+	case ">":
+		return v > n.values[0]
+	case ">=":
+		return v >= n.values[0]
+	}
+
+	matched := false
+	for _, want := range n.values {
+		if v == want {
+			matched = true
+			break
+		}
+	}
+	if n.op == "!=" {
+		return !matched
+	}
+	return matched
+}
+
+type ipNode struct {
+	f        field
+	op       string
+	prefixes []netip.Prefix
+}
+
+func (n *ipNode) eval(dl *types.Dnslog) bool {
+	matched := false
+	for _, ip := range n.f.ips(dl) {
+		addr := iptrie.FromIP(ip)
+		for _, p := range n.prefixes {
+			if p.Contains(addr) {
+				matched = true
+				break
+			}
+		}
+	}
+	if n.op == "!=" {
+		return !matched
+	}
+	return matched
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isKeyword(t token, typ tokenType, word string) bool {
+	return t.typ == typ || (t.typ == tokenWord && strings.EqualFold(t.val, word))
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword(p.peek(), tokenOr, "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword(p.peek(), tokenAnd, "and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isKeyword(p.peek(), tokenNot, "not") {
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{inner}, nil
+	}
+
+	if p.peek().typ == tokenLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.typ != tokenRParen {
+			return nil, fmt.Errorf("expect ) but got %s", t)
+		}
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	t := p.next()
+	if t.typ != tokenWord {
+		return nil, fmt.Errorf("expect field but got %s", t)
+	}
+
+	name := strings.ToLower(t.val)
+	if alias, ok := fieldAliases[name]; ok {
+		name = alias
+	}
+	f, ok := fields[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %s", t)
+	}
+
+	opToken := p.peek()
+	var op string
+	var literals []token
+	switch {
+	case opToken.typ == tokenOp:
+		p.next()
+		op = opToken.val
+		if op == "=" {
+			op = "=="
+		}
+		lit := p.next()
+		if lit.typ != tokenWord && lit.typ != tokenString {
+			return nil, fmt.Errorf("expect value but got %s", lit)
+		}
+		literals = []token{lit}
+	case opToken.typ == tokenWord && strings.EqualFold(opToken.val, "in"):
+		p.next()
+		op = "in"
+		list, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		literals = list
+	default:
+		if f.kind != kindBool {
+			return nil, fmt.Errorf("expect operator after %s but got %s", t.val, opToken)
+		}
+		return &boolNode{f: f, want: true}, nil
+	}
+
+	return buildComparison(name, f, op, literals)
+}
+
+func (p *parser) parseList() ([]token, error) {
+	if t := p.next(); t.typ != tokenLParen {
+		return nil, fmt.Errorf("expect ( but got %s", t)
+	}
+
+	list := []token{}
+	for {
+		lit := p.next()
+		if lit.typ != tokenWord && lit.typ != tokenString {
+			return nil, fmt.Errorf("expect value but got %s", lit)
+		}
+		list = append(list, lit)
+
+		t := p.next()
+		if t.typ == tokenRParen {
+			return list, nil
+		}
+		if t.typ != tokenComma {
+			return nil, fmt.Errorf("expect , or ) but got %s", t)
+		}
+	}
+}
+
+func buildComparison(name string, f field, op string, literals []token) (node, error) {
+	checkOp := func(allowed ...string) error {
+		for _, a := range allowed {
+			if a == op {
+				return nil
+			}
+		}
+		return fmt.Errorf("operator %s not supported on %s field %s", op, f.kind, name)
+	}
+
+	switch f.kind {
+	case kindString:
+		if err := checkOp("==", "!=", "~", "!~", "in"); err != nil {
+			return nil, err
+		}
+		n := &stringNode{f: f, op: op, pattern: op == "~" || op == "!~"}
+		for _, l := range literals {
+			v := l.val
+			isName := name == "qname" || name == "regdomain"
+			if isName && !n.pattern {
+				v = dns.Fqdn(v)
+			}
+			if isName && n.pattern && !strings.HasSuffix(v, ".") && !strings.HasSuffix(v, "*") {
+				v += "."
+			}
+			if n.pattern {
+				v = strings.ToLower(v)
+			}
+			n.values = append(n.values, v)
+		}
+		return n, nil
+	case kindNumber, kindDuration:
+		if err := checkOp("==", "!=", "<", "<=", ">", ">=", "in"); err != nil {
+			return nil, err
+		}
+		n := &numberNode{f: f, op: op}
+		for _, l := range literals {
+			v, err := parseNumber(f.kind, l.val)
+			if err != nil {
+				return nil, fmt.Errorf("field %s %s", name, err)
+			}
+			n.values = append(n.values, v)
+		}
+		return n, nil
+	case kindIP:
+		if err := checkOp("==", "!=", "in"); err != nil {
+			return nil, err
+		}
+		n := &ipNode{f: f, op: op}
+		for _, l := range literals {
+			ps, err := iptrie.Parse(l.val)
+			if err != nil {
+				return nil, fmt.Errorf("field %s %s", name, err)
+			}
+			n.prefixes = append(n.prefixes, ps...)
+		}
+		return n, nil
+	default:
+		if err := checkOp("==", "!="); err != nil {
+			return nil, err
+		}
+		want, err := strconv.ParseBool(literals[0].val)
+		if err != nil {
+			return nil, fmt.Errorf("field %s invalid bool %s", name, literals[0].val)
+		}
+		return &boolNode{f: f, want: want == (op == "==")}, nil
+	}
+}
+
+func parseNumber(kind fieldKind, s string) (int64, error) {
+	if kind == kindDuration {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return int64(time.Duration(n) * time.Millisecond), nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %s", s)
+		}
+		return int64(d), nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %s", s)
+	}
+	return n, nil
+}
+
+func globMatch(pattern, s string) bool {
+	px, sx := 0, 0
+	starPx, starSx := -1, 0
+	for sx < len(s) {
+		switch {
+		case px < len(pattern) && (pattern[px] == '?' || pattern[px] == s[sx]):
+			px++
+			sx++
+		case px < len(pattern) && pattern[px] == '*':
+			starPx, starSx = px, sx
+			px++
+		case starPx >= 0:
+			px = starPx + 1
+			starSx++
+			sx = starSx
+		default:
+			return false
+		}
+	}
+	for px < len(pattern) && pattern[px] == '*' {
+		px++
+	}
+	return px == len(pattern)
+}
@@ -0,0 +1,82 @@
+package filter
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+func TestFilterMatch(t *testing.T) {
+	dl := &types.Dnslog{
+		SrcIP:            net.ParseIP("10.1.1.1"),
+		DstIP:            net.ParseIP("192.168.0.53"),
+		SrcPort:          53,
+		DstPort:          40000,
+		Domain:           "Www.Corp.Example.",
+		RegisteredDomain: "corp.example.",
+		QueryClass:       "IN",
+		QueryType:        "AAAA",
+		Rcode:            "SERVFAIL",
+		Response:         true,
+		ResolvDuration:   150 * time.Millisecond,
+	}
+
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`qname ~ "*.corp.example." and rcode != NOERROR and latency > 100ms`, true},
+		{`qname ~ "*.corp.example" && latency > 200`, false},
+		{`qname == www.corp.example`, true},
+		{`qname !~ "*.corp.example."`, false},
+		{`regdomain == corp.example`, true},
+		{`regdomain ~ "*.example"`, true},
+		{`qtype in (A, AAAA) and response`, true},
+		{`not response or tc`, false},
+		{`!(rcode == NOERROR)`, true},
+		{`src == 10.0.0.0/8 and dst != 10.0.0.0/8`, true},
+		{`ip in (172.16.0.0/12, 192.168.0.53)`, true},
+		{`sport = 53 and dport >= 1024 and type == response`, true},
+		{`rd == false`, true},
+	}
+
+	for _, c := range cases {
+		f, err := Compile(c.expr)
+		if err != nil {
+			t.Fatalf("compile %s failed %s", c.expr, err)
+		}
+		if got := f.Match(dl); got != c.want {
+			t.Fatalf("filter %s got %v want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestFilterEmpty(t *testing.T) {
+	f, err := Compile("  ")
+	if err != nil {
+		t.Fatalf("compile empty filter failed %s", err)
+	}
+	if !f.Match(&types.Dnslog{}) {
+		t.Fatalf("empty filter should match all")
+	}
+}
+
+func TestFilterCompileError(t *testing.T) {
+	for _, expr := range []string{
+		`qname`,
+		`unknown == 1`,
+		`latency ~ 10ms`,
+		`latency > soon`,
+		`src == 10.0.0.300`,
+		`(qtype == A`,
+		`qtype == A rcode == NOERROR`,
+		`qname == "unterminated`,
+		`qtype in (A AAAA)`,
+	} {
+		if _, err := Compile(expr); err == nil {
+			t.Fatalf("compile %s should fail", expr)
+		}
+	}
+}
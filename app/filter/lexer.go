@@ -0,0 +1,99 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenWord
+	tokenString
+	tokenOp
+	tokenNot
+	tokenAnd
+	tokenOr
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+func (t token) String() string {
+	if t.typ == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q at %d", t.val, t.pos)
+}
+
+const wordStopChars = "()!=<>~,\"&|"
+
+func lex(input string) ([]token, error) {
+	tokens := []token{}
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case c == '"':
+			end := strings.IndexByte(input[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{tokenString, input[i+1 : i+1+end], i})
+			i += end + 2
+		case c == '&' || c == '|':
+			if i+1 >= len(input) || input[i+1] != c {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			typ := tokenAnd
+			if c == '|' {
+				typ = tokenOr
+			}
+			tokens = append(tokens, token{typ, input[i : i+2], i})
+			i += 2
+		case c == '!':
+			if i+1 < len(input) && (input[i+1] == '=' || input[i+1] == '~') {
+				tokens = append(tokens, token{tokenOp, input[i : i+2], i})
+				i += 2
+			} else {
+				tokens = append(tokens, token{tokenNot, "!", i})
+				i++
+			}
+		case c == '=' || c == '<' || c == '>' || c == '~':
+			if i+1 < len(input) && input[i+1] == '=' && c != '~' {
+				tokens = append(tokens, token{tokenOp, input[i : i+2], i})
+				i += 2
+			} else {
+				tokens = append(tokens, token{tokenOp, input[i : i+1], i})
+				i++
+			}
+		default:
+			start := i
+			for i < len(input) && !strings.ContainsRune(wordStopChars, rune(input[i])) &&
+				input[i] != ' ' && input[i] != '\t' && input[i] != '\n' && input[i] != '\r' {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, input[start:i], start})
+		}
+	}
+	tokens = append(tokens, token{tokenEOF, "", len(input)})
+	return tokens, nil
+}
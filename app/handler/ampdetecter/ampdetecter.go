@@ -0,0 +1,112 @@
+package ampdetecter
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/natefinch/lumberjack"
+)
+
+const (
+	taskChannelBuffer = 100
+)
+
+// New writes the suspected victims and abused names every interval of packet
+// time. Recursion traffic of the server itself is skipped.
+func New(filename string, interval time.Duration, factor float64, minQueries int, window time.Duration, minSources int, classifier *handler.Classifier) *AmpDetecter {
+	a := &AmpDetecter{
+		detector:   NewDetector(factor, minQueries, window, minSources),
+		classifier: classifier,
+		interval:   interval,
+		writer: &lumberjack.Logger{
+			Filename:   filename,
+			MaxSize:    50,
+			MaxBackups: 10,
+			MaxAge:     100,
+			Compress:   true,
+		},
+		taskCh:  make(chan *types.Dnslog, taskChannelBuffer),
+		closeCh: make(chan struct{}),
+	}
+
+	go a.loop()
+	return a
+}
+
+type AmpDetecter struct {
+	begin      bool
+	endTime    time.Time
+	detector   *Detector
+	classifier *handler.Classifier
+	interval   time.Duration
+	writer     *lumberjack.Logger
+	taskCh     chan *types.Dnslog
+	closeCh    chan struct{}
+}
+
+type Report struct {
+	BeginTime time.Time `json:"begin_time"`
+	EndTime   time.Time `json:"end_time"`
+	Victims   []*Source `json:"victims"`
+	Names     []*Name   `json:"names"`
+}
+
+func (a *AmpDetecter) Handle(dl *types.Dnslog) {
+	a.taskCh <- dl
+}
+
+func (a *AmpDetecter) Stop() {
+	close(a.taskCh)
+	<-a.closeCh
+	a.writer.Close()
+}
+
+func (a *AmpDetecter) loop() {
+	for {
+		dl, ok := <-a.taskCh
+		if !ok {
+			a.out()
+			a.closeCh <- struct{}{}
+			logger.Infof("amplification detecter handler exiting")
+			return
+		}
+
+		if !a.begin {
+			a.endTime = dl.PacketTime.Add(a.interval)
+			a.begin = true
+		}
+		if dl.PacketTime.After(a.endTime) {
+			a.out()
+			a.endTime = a.endTime.Add(a.interval)
+		}
+
+		if !a.classifier.IsRecursion(dl) {
+			a.detector.Add(dl)
+		}
+	}
+}
+
+func (a *AmpDetecter) out() {
+	if !a.begin {
+		return
+	}
+
+	r := &Report{
+		BeginTime: a.endTime.Local().Add(-a.interval),
+		EndTime:   a.endTime.Local(),
+	}
+	r.Victims, r.Names = a.detector.Report()
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		logger.Errorf("amplification report marshal to json failed %s", err)
+		return
+	}
+
+	if _, err := a.writer.Write(append(b, '\n')); err != nil {
+		logger.Errorf("write file %s failed %s", a.writer.Filename, err)
+	}
+}
@@ -0,0 +1,211 @@
+package ampdetecter
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+const (
+	largeResponseSize = 1000
+	largeTypeShare    = 0.5
+	maxSampleSources  = 10
+)
+
+// largeTypes are the query types answered far larger than asked, favoured by
+// amplification attacks.
+var largeTypes = map[string]struct{}{
+	"ANY":    {},
+	"TXT":    {},
+	"DNSKEY": {},
+}
+
+// Source is a query source address, which is the victim when the queries
+// are spoofed.
+type Source struct {
+	Source         string  `json:"source"`
+	Queries        int     `json:"queries"`
+	QueryBytes     int     `json:"query_bytes"`
+	Responses      int     `json:"responses"`
+	ResponseBytes  int     `json:"response_bytes"`
+	Amplification  float64 `json:"amplification"`
+	AnyQueries     int     `json:"any_queries"`
+	TxtQueries     int     `json:"txt_queries"`
+	DnskeyQueries  int     `json:"dnskey_queries"`
+	LargeTypeShare float64 `json:"large_type_share"`
+}
+
+type Name struct {
+	Name            string   `json:"name"`
+	QueryType       string   `json:"query_type"`
+	MaxSources      int      `json:"max_sources"`
+	Queries         int      `json:"queries"`
+	Responses       int      `json:"responses"`
+	ResponseBytes   int      `json:"response_bytes"`
+	AvgResponseSize int      `json:"avg_response_size"`
+	Sources         []string `json:"sources"`
+}
+
+// NewDetector counts the bytes and query types of every source and the
+// distinct sources of the large names within every window. Sources sending
+// at least minQueries queries with amplification of factor or more, or
+// mostly large type queries, are reported as victims. Names asked by at least
+// minSources sources within a window are reported as abused.
+func NewDetector(factor float64, minQueries int, window time.Duration, minSources int) *Detector {
+	d := &Detector{
+		factor:     factor,
+		minQueries: minQueries,
+		window:     window,
+		minSources: minSources,
+	}
+	d.reset()
+	return d
+}
+
+type Detector struct {
+	factor        float64
+	minQueries    int
+	window        time.Duration
+	minSources    int
+	sources       map[string]*Source
+	names         map[string]*Name
+	windowEnd     time.Time
+	windowSources map[string]map[string]struct{}
+}
+
+func (d *Detector) reset() {
+	d.sources = map[string]*Source{}
+	d.names = map[string]*Name{}
+	d.windowSources = map[string]map[string]struct{}{}
+}
+
+func (d *Detector) Add(dl *types.Dnslog) {
+	if dl.PacketTime.After(d.windowEnd) {
+		d.closeWindow()
+		d.windowEnd = dl.PacketTime.Add(d.window)
+	}
+
+	source := dl.SrcIP.String()
+	if dl.Response {
+		source = dl.DstIP.String()
+	}
+	s, ok := d.sources[source]
+	if !ok {
+		s = &Source{Source: source}
+		d.sources[source] = s
+	}
+
+	_, largeType := largeTypes[dl.QueryType]
+	if !dl.Response {
+		s.Queries++
+		s.QueryBytes += dl.QuerySize
+		switch dl.QueryType {
+		case "ANY":
+			s.AnyQueries++
+		case "TXT":
+			s.TxtQueries++
+		case "DNSKEY":
+			s.DnskeyQueries++
+		}
+	} else {
+		s.Responses++
+		s.ResponseBytes += dl.ResponseSize
+	}
+
+	if !largeType && dl.ResponseSize < largeResponseSize {
+		return
+	}
+
+	k := strings.ToLower(dl.Domain) + "|" + dl.QueryType
+	n, ok := d.names[k]
+	if !ok {
+		n = &Name{Name: strings.ToLower(dl.Domain), QueryType: dl.QueryType, Sources: []string{}}
+		d.names[k] = n
+	}
+	if dl.Response {
+		n.Responses++
+		n.ResponseBytes += dl.ResponseSize
+	} else {
+		n.Queries++
+	}
+
+	ss, ok := d.windowSources[k]
+	if !ok {
+		ss = map[string]struct{}{}
+		d.windowSources[k] = ss
+	}
+	ss[source] = struct{}{}
+}
+
+func (d *Detector) closeWindow() {
+	for k, ss := range d.windowSources {
+		n := d.names[k]
+		if len(ss) < d.minSources || len(ss) <= n.MaxSources {
+			continue
+		}
+
+		n.MaxSources = len(ss)
+		n.Sources = []string{}
+		for s := range ss {
+			n.Sources = append(n.Sources, s)
+		}
+		sort.Strings(n.Sources)
+		if len(n.Sources) > maxSampleSources {
+			n.Sources = n.Sources[:maxSampleSources]
+		}
+	}
+	d.windowSources = map[string]map[string]struct{}{}
+}
+
+// Report returns the suspected victims sorted by response bytes and the
+// abused names sorted by sources, then starts counting over.
+func (d *Detector) Report() ([]*Source, []*Name) {
+	d.closeWindow()
+
+	victims := []*Source{}
+	for _, s := range d.sources {
+		if s.Queries < d.minQueries {
+			continue
+		}
+		if s.QueryBytes > 0 {
+			s.Amplification = round(float64(s.ResponseBytes) / float64(s.QueryBytes))
+		}
+		s.LargeTypeShare = round(float64(s.AnyQueries+s.TxtQueries+s.DnskeyQueries) / float64(s.Queries))
+		if s.Amplification >= d.factor || s.LargeTypeShare >= largeTypeShare {
+			victims = append(victims, s)
+		}
+	}
+	sort.Slice(victims, func(i, j int) bool {
+		if victims[i].ResponseBytes != victims[j].ResponseBytes {
+			return victims[i].ResponseBytes > victims[j].ResponseBytes
+		}
+		return victims[i].Source < victims[j].Source
+	})
+
+	names := []*Name{}
+	for _, n := range d.names {
+		if n.MaxSources == 0 {
+			continue
+		}
+		if n.Responses > 0 {
+			n.AvgResponseSize = n.ResponseBytes / n.Responses
+		}
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].MaxSources != names[j].MaxSources {
+			return names[i].MaxSources > names[j].MaxSources
+		}
+		return names[i].Name+names[i].QueryType < names[j].Name+names[j].QueryType
+	})
+
+	d.reset()
+	return victims, names
+}
+
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,53 @@
+package ampdetecter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/hiwyw/dnscap-go/app/types/dnslogtest"
+)
+
+func packet(ms int, source, name, qtype string, response bool, size int) *types.Dnslog {
+	if !response {
+		dl := dnslogtest.Packet(ms, source, "10.0.0.53", 40000, 53, 0, name, false)
+		dl.QueryType, dl.QuerySize = qtype, size
+		return dl
+	}
+	dl := dnslogtest.Packet(ms, "10.0.0.53", source, 53, 40000, 0, name, true)
+	dl.QueryType, dl.ResponseSize = qtype, size
+	return dl
+}
+
+func TestDetector(t *testing.T) {
+	d := NewDetector(10, 5, 10*time.Second, 20)
+
+	// a spoofed victim and a normal client
+	for i := 0; i < 10; i++ {
+		d.Add(packet(i, "203.0.113.7", "example.com.", "ANY", false, 40))
+		d.Add(packet(i, "203.0.113.7", "example.com.", "ANY", true, 3000))
+		d.Add(packet(i, "10.1.1.1", "www.example.com.", "A", false, 40))
+		d.Add(packet(i, "10.1.1.1", "www.example.com.", "A", true, 80))
+	}
+
+	// the same large name from many sources within the window, and slowly
+	for i := 0; i < 30; i++ {
+		d.Add(packet(100+i, fmt.Sprintf("198.51.100.%d", i), "big.example.", "TXT", false, 40))
+	}
+	for i := 0; i < 30; i++ {
+		d.Add(packet(1000+i*1000, fmt.Sprintf("192.0.2.%d", i), "slow.example.", "TXT", false, 40))
+	}
+
+	victims, names := d.Report()
+	if len(victims) != 1 || victims[0].Source != "203.0.113.7" || victims[0].Amplification != 75 || victims[0].LargeTypeShare != 1 {
+		t.Fatalf("unexpected victims %+v", victims)
+	}
+	if len(names) != 1 || names[0].Name != "big.example." || names[0].MaxSources != 30 || len(names[0].Sources) != maxSampleSources {
+		t.Fatalf("unexpected names %+v", names)
+	}
+
+	if victims, names := d.Report(); len(victims) != 0 || len(names) != 0 {
+		t.Fatalf("report should start counting over")
+	}
+}
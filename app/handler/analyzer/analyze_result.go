@@ -2,9 +2,11 @@ package analyzer
 
 import (
 	"encoding/json"
+	"sort"
 	"time"
 
 	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/pkg/publicsuffix"
 	"github.com/hiwyw/dnscap-go/app/types"
 )
 
@@ -14,24 +16,52 @@ const (
 	delayLess1000ms = "100-1000ms"
 	delayLess3000ms = "1000-3000ms"
 	delayMore3000ms = "3000ms+"
+
+	topDomains = 20
 )
 
-func NewResult(interval time.Duration, ips, domains []string) *Result {
+func NewResult(interval time.Duration, ips, clients, domains []string, sampleRate int, estimateCache, upstream bool, group publicsuffix.Group) *Result {
+	if sampleRate < 1 {
+		sampleRate = 1
+	}
+
 	ipCount := map[string]*CountResult{}
 	for _, ip := range ips {
-		ipCount[ip] = NewCountResult(false, false)
+		ipCount[ip] = NewCountResult(false, false, sampleRate)
+	}
+
+	var clientCount map[string]*CountResult
+	if len(clients) > 0 {
+		clientCount = map[string]*CountResult{}
+		for _, c := range clients {
+			clientCount[c] = NewCountResult(true, true, sampleRate)
+		}
 	}
 
 	domainCount := map[string]*CountResult{}
 	for _, domain := range domains {
-		domainCount[domain] = NewCountResult(false, false)
+		domainCount[domain] = NewCountResult(false, false, sampleRate)
 	}
 
 	r := &Result{
-		ClientCount:         NewCountResult(true, true),
-		RecursionCount:      NewCountResult(true, true),
+		Sampled:             sampleRate > 1,
+		SampleRate:          sampleRate,
+		ClientCount:         NewCountResult(true, true, sampleRate),
+		RecursionCount:      NewCountResult(true, true, sampleRate),
 		SpecialIpCounts:     ipCount,
+		ClientGroupCounts:   clientCount,
 		SpecialDomainCounts: domainCount,
+		group:               group,
+	}
+
+	if group.Enabled() {
+		r.domainQueries = map[string]int{}
+	}
+	if estimateCache {
+		r.CacheEstimate = NewCacheResult(sampleRate, group)
+	}
+	if upstream {
+		r.Upstream = NewUpstreamResult(sampleRate)
 	}
 	return r
 }
@@ -39,50 +69,104 @@ func NewResult(interval time.Duration, ips, domains []string) *Result {
 type Result struct {
 	BeginTime           time.Time               `json:"begin_time"`
 	EndTime             time.Time               `json:"end_time"`
+	Sampled             bool                    `json:"sampled"`
+	SampleRate          int                     `json:"sample_rate"`
 	ClientCount         *CountResult            `json:"client_side"`
 	RecursionCount      *CountResult            `json:"recursion_side"`
 	SpecialIpCounts     map[string]*CountResult `json:"special_ips"`
+	ClientGroupCounts   map[string]*CountResult `json:"client_groups,omitempty"`
 	SpecialDomainCounts map[string]*CountResult `json:"special_domains"`
+	TopDomains          []*DomainQueryCount     `json:"top_domains,omitempty"`
+	CacheEstimate       *CacheResult            `json:"cache_estimate,omitempty"`
+	Upstream            *UpstreamResult         `json:"upstream,omitempty"`
+	group               publicsuffix.Group
+	domainQueries       map[string]int
+}
+
+type DomainQueryCount struct {
+	Domain     string `json:"domain"`
+	QueryCount int    `json:"query_count"`
 }
 
-func (r *Result) count(dl *types.Dnslog, isRecurseion bool) {
+func (r *Result) count(dl *types.Dnslog, isRecurseion bool, ipGroups []string, clientGroup string, domainGroups []string) {
 	if isRecurseion {
 		r.RecursionCount.count(dl)
 	} else {
 		r.ClientCount.count(dl)
-		r.countDomain(dl)
+		r.countDomain(dl, domainGroups)
+		if c, ok := r.ClientGroupCounts[clientGroup]; ok {
+			c.count(dl)
+		}
 	}
-	r.countIp(dl)
+	r.countIp(dl, ipGroups)
 }
 
-func (r *Result) countIp(dl *types.Dnslog) {
-	if len(r.SpecialIpCounts) == 0 {
-		return
+func (r *Result) countIp(dl *types.Dnslog, ipGroups []string) {
+	for _, g := range ipGroups {
+		if c, ok := r.SpecialIpCounts[g]; ok {
+			c.count(dl)
+		}
 	}
+}
 
-	srcIp := dl.SrcIP.String()
-	if _, ok := r.SpecialIpCounts[srcIp]; ok {
-		r.SpecialIpCounts[srcIp].count(dl)
+func (r *Result) countDomain(dl *types.Dnslog, domainGroups []string) {
+	for _, g := range domainGroups {
+		if c, ok := r.SpecialDomainCounts[g]; ok {
+			c.count(dl)
+		}
 	}
 
-	dstIp := dl.DstIP.String()
-	if _, ok := r.SpecialIpCounts[dstIp]; ok {
-		r.SpecialIpCounts[dstIp].count(dl)
+	if r.domainQueries != nil && !dl.Response {
+		r.domainQueries[r.group.Key(dl.Domain, dl.RegisteredDomain)] += r.SampleRate
 	}
 }
 
-func (r *Result) countDomain(dl *types.Dnslog) {
-	if len(r.SpecialDomainCounts) == 0 {
-		return
+func (r *Result) summarize() {
+	counts := []*CountResult{r.ClientCount, r.RecursionCount}
+	for _, c := range r.SpecialIpCounts {
+		counts = append(counts, c)
+	}
+	for _, c := range r.SpecialDomainCounts {
+		counts = append(counts, c)
+	}
+	for _, c := range r.ClientGroupCounts {
+		counts = append(counts, c)
+	}
+
+	for _, c := range counts {
+		if c.QueryCount > 0 {
+			c.RetryRate = float64(c.RetryCount) / float64(c.QueryCount)
+		}
+	}
+
+	if r.CacheEstimate != nil {
+		r.CacheEstimate.summarize()
+	}
+
+	if r.Upstream != nil {
+		r.Upstream.summarize()
 	}
 
-	_, ok := r.SpecialDomainCounts[dl.Domain]
-	if ok {
-		r.SpecialDomainCounts[dl.Domain].count(dl)
+	if r.domainQueries != nil {
+		top := []*DomainQueryCount{}
+		for d, c := range r.domainQueries {
+			top = append(top, &DomainQueryCount{Domain: d, QueryCount: c})
+		}
+		sort.Slice(top, func(i, j int) bool {
+			if top[i].QueryCount != top[j].QueryCount {
+				return top[i].QueryCount > top[j].QueryCount
+			}
+			return top[i].Domain < top[j].Domain
+		})
+		if len(top) > topDomains {
+			top = top[:topDomains]
+		}
+		r.TopDomains = top
 	}
 }
 
 func (r *Result) Json() []byte {
+	r.summarize()
 	b, err := json.MarshalIndent(r, "", "    ")
 	if err != nil {
 		logger.Errorf("analyze result marshal to json failed %s", err)
@@ -91,15 +175,11 @@ func (r *Result) Json() []byte {
 	return b
 }
 
-func NewCountResult(enableRcode, enableQtype bool) *CountResult {
+func NewCountResult(enableRcode, enableQtype bool, weight int) *CountResult {
 	r := &CountResult{
-		DelayCount: map[string]int{
-			delayLess10ms:   0,
-			delayLess100ms:  0,
-			delayLess1000ms: 0,
-			delayLess3000ms: 0,
-			delayMore3000ms: 0,
-		},
+		weight:          weight,
+		DelayCount:      newDelayCount(),
+		FirstDelayCount: newDelayCount(),
 	}
 
 	if enableRcode {
@@ -112,48 +192,68 @@ func NewCountResult(enableRcode, enableQtype bool) *CountResult {
 	return r
 }
 
+func newDelayCount() map[string]int {
+	return map[string]int{
+		delayLess10ms:   0,
+		delayLess100ms:  0,
+		delayLess1000ms: 0,
+		delayLess3000ms: 0,
+		delayMore3000ms: 0,
+	}
+}
+
+// CountResult buckets the latency of a response from the last retry of the
+// query in DelayCount and from its first send in FirstDelayCount.
 type CountResult struct {
-	QueryCount     int            `json:"query_count"`
-	ResponseCount  int            `json:"response_count"`
-	DelayCount     map[string]int `json:"delay_statistics"`
-	RcodeCount     map[string]int `json:"rcode_statistics,omitempty"`
-	QueryTypeCount map[string]int `json:"qtype_statistics,omitempty"`
+	QueryCount      int            `json:"query_count"`
+	ResponseCount   int            `json:"response_count"`
+	RetryCount      int            `json:"retry_count"`
+	RetryRate       float64        `json:"retry_rate"`
+	DelayCount      map[string]int `json:"delay_statistics"`
+	FirstDelayCount map[string]int `json:"first_delay_statistics"`
+	RcodeCount      map[string]int `json:"rcode_statistics,omitempty"`
+	QueryTypeCount  map[string]int `json:"qtype_statistics,omitempty"`
+	weight          int
 }
 
 func (c *CountResult) count(dl *types.Dnslog) {
 	if dl.Response {
-		c.ResponseCount++
-		c.countDelay(dl.ResolvDuration)
+		c.ResponseCount += c.weight
+		c.countDelay(c.DelayCount, dl.ResolvDuration)
+		c.countDelay(c.FirstDelayCount, dl.FirstResolvDuration)
 		c.countRcode(dl.Rcode)
 		c.countQtype(dl.QueryType)
 	} else {
-		c.QueryCount++
+		c.QueryCount += c.weight
+		if dl.Retries > 0 {
+			c.RetryCount += c.weight
+		}
 	}
 }
 
-func (c *CountResult) countDelay(d time.Duration) {
+func (c *CountResult) countDelay(counts map[string]int, d time.Duration) {
 	if d.Milliseconds() <= 10 {
-		c.DelayCount[delayLess10ms]++
+		counts[delayLess10ms] += c.weight
 		return
 	}
 
 	if d.Milliseconds() > 10 && d.Milliseconds() <= 100 {
-		c.DelayCount[delayLess100ms]++
+		counts[delayLess100ms] += c.weight
 		return
 	}
 
 	if d.Milliseconds() > 100 && d.Milliseconds() <= 1000 {
-		c.DelayCount[delayLess1000ms]++
+		counts[delayLess1000ms] += c.weight
 		return
 	}
 
-	if d.Milliseconds() > 1000 && int64(d) <= 3000 {
-		c.DelayCount[delayLess3000ms]++
+	if d.Milliseconds() > 1000 && d.Milliseconds() <= 3000 {
+		counts[delayLess3000ms] += c.weight
 		return
 	}
 
 	if d.Milliseconds() > 3000 {
-		c.DelayCount[delayMore3000ms]++
+		counts[delayMore3000ms] += c.weight
 		return
 	}
 }
@@ -163,12 +263,7 @@ func (c *CountResult) countRcode(code string) {
 		return
 	}
 
-	_, ok := c.RcodeCount[code]
-	if !ok {
-		c.RcodeCount[code] = 1
-	} else {
-		c.RcodeCount[code]++
-	}
+	c.RcodeCount[code] += c.weight
 }
 
 func (c *CountResult) countQtype(t string) {
@@ -176,10 +271,5 @@ func (c *CountResult) countQtype(t string) {
 		return
 	}
 
-	_, ok := c.QueryTypeCount[t]
-	if !ok {
-		c.QueryTypeCount[t] = 1
-	} else {
-		c.QueryTypeCount[t]++
-	}
+	c.QueryTypeCount[t] += c.weight
 }
@@ -1,9 +1,15 @@
 package analyzer
 
 import (
+	"net/netip"
 	"time"
 
+	"github.com/hiwyw/dnscap-go/app/handler"
 	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/pkg/correlate"
+	"github.com/hiwyw/dnscap-go/app/pkg/domaintrie"
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+	"github.com/hiwyw/dnscap-go/app/pkg/publicsuffix"
 	"github.com/hiwyw/dnscap-go/app/types"
 	"github.com/natefinch/lumberjack"
 )
@@ -12,15 +18,48 @@ const (
 	taskChannelBuffer = 100
 )
 
-func New(filename string, interval time.Duration, ips, domains, selfIps []string) *Analyzer {
-	ipsMap := map[string]struct{}{}
-	for _, ip := range selfIps {
-		ipsMap[ip] = struct{}{}
+// New builds an analyzer, the clients in none of clientGroups are counted
+// under otherClientGroup.
+func New(filename string, interval time.Duration, ipGroups, clientGroups map[string][]netip.Prefix, otherClientGroup string, domains []string, group publicsuffix.Group, classifier *handler.Classifier, sampleRate int, txTimeout, cacheHitLatency time.Duration) *Analyzer {
+	ips := []string{}
+	ipTrie := iptrie.New[string]()
+	for name, prefixes := range ipGroups {
+		ips = append(ips, name)
+		for _, p := range prefixes {
+			ipTrie.Insert(p, name)
+		}
+	}
+
+	clients := []string{}
+	clientTrie := iptrie.New[string]()
+	for name, prefixes := range clientGroups {
+		clients = append(clients, name)
+		for _, p := range prefixes {
+			clientTrie.Insert(p, name)
+		}
+	}
+	if len(clients) > 0 {
+		clients = append(clients, otherClientGroup)
+	}
+
+	domainTrie := domaintrie.New[string]()
+	for _, d := range domains {
+		if err := domainTrie.Insert(d, d); err != nil {
+			logger.Errorf("add analyze domain %s failed %s", d, err)
+		}
+	}
+
+	// sampling keeps a client transaction and the upstream queries it
+	// triggers independently, which would count dropped upstream legs as hits
+	estimateCache := classifier.HasSelfIps() && sampleRate <= 1
+	if classifier.HasSelfIps() && !estimateCache {
+		logger.Warnf("cache estimate disabled with sample rate %d", sampleRate)
 	}
 
 	a := &Analyzer{
-		selfIps: ipsMap,
-		taskCh:  make(chan *types.Dnslog, taskChannelBuffer),
+		classifier: classifier,
+		upstream:   newUpstreamTracker(txTimeout),
+		taskCh:     make(chan *types.Dnslog, taskChannelBuffer),
 		outLogger: &lumberjack.Logger{
 			Filename:   filename,
 			MaxSize:    50,
@@ -28,11 +67,25 @@ func New(filename string, interval time.Duration, ips, domains, selfIps []string
 			MaxAge:     100,
 			Compress:   true,
 		},
-		ips:      ips,
-		domains:  domains,
-		interval: interval,
-		result:   NewResult(interval, ips, domains),
-		closeCh:  make(chan struct{}),
+		ips:        ips,
+		ipTrie:     ipTrie,
+		domains:    domains,
+		domainTrie: domainTrie,
+		interval:   interval,
+		group:      group,
+		clients:    clients,
+		clientTrie: clientTrie,
+		other:      otherClientGroup,
+		result:     NewResult(interval, ips, clients, domains, sampleRate, estimateCache, classifier.HasSelfIps(), group),
+		sample:     sampleRate,
+		cache:      estimateCache,
+		hasSelfIps: classifier.HasSelfIps(),
+		closeCh:    make(chan struct{}),
+	}
+
+	if estimateCache {
+		a.engine = correlate.NewEngine(classifier, txTimeout)
+		a.estimator = newCacheEstimator(cacheHitLatency)
 	}
 
 	go a.taskLoop()
@@ -41,16 +94,28 @@ func New(filename string, interval time.Duration, ips, domains, selfIps []string
 }
 
 type Analyzer struct {
-	begin     bool
-	endTime   time.Time
-	selfIps   map[string]struct{}
-	ips       []string
-	domains   []string
-	interval  time.Duration
-	taskCh    chan *types.Dnslog
-	outLogger *lumberjack.Logger
-	result    *Result
-	closeCh   chan struct{}
+	begin      bool
+	endTime    time.Time
+	classifier *handler.Classifier
+	engine     *correlate.Engine
+	estimator  *cacheEstimator
+	upstream   *upstreamTracker
+	ips        []string
+	ipTrie     *iptrie.Trie[string]
+	clients    []string
+	clientTrie *iptrie.Trie[string]
+	other      string
+	domains    []string
+	domainTrie *domaintrie.Trie[string]
+	group      publicsuffix.Group
+	interval   time.Duration
+	sample     int
+	cache      bool
+	hasSelfIps bool
+	taskCh     chan *types.Dnslog
+	outLogger  *lumberjack.Logger
+	result     *Result
+	closeCh    chan struct{}
 }
 
 func (a *Analyzer) Handle(dl *types.Dnslog) {
@@ -66,6 +131,9 @@ func (a *Analyzer) taskLoop() {
 	for {
 		dl, ok := <-a.taskCh
 		if !ok {
+			if a.engine != nil {
+				a.countCache(a.engine.Flush())
+			}
 			a.out()
 			a.closeCh <- struct{}{}
 			logger.Infof("analyze handleer exitting")
@@ -86,10 +154,19 @@ func (a *Analyzer) analyze(dl *types.Dnslog) {
 		a.endTime = a.endTime.Add(a.interval)
 	}
 
-	if a.isRecursion(dl) {
-		a.result.count(dl, true)
-	} else {
-		a.result.count(dl, false)
+	isRecursion := a.classifier.IsRecursion(dl)
+	a.result.count(dl, isRecursion, a.ipGroups(dl), a.clientGroup(dl), a.domainGroups(dl))
+
+	if a.engine != nil {
+		a.countCache(a.engine.Add(dl))
+		a.estimator.observe(dl, isRecursion)
+	}
+
+	if a.result.Upstream != nil {
+		a.upstream.expire(dl.PacketTime, a.result.Upstream)
+		if isRecursion {
+			a.upstream.observe(dl, a.result.Upstream)
+		}
 	}
 }
 
@@ -115,10 +192,72 @@ func (a *Analyzer) out() {
 	}
 
 	logger.Infof("output analyze result succeed")
-	a.result = NewResult(a.interval, a.ips, a.domains)
+	a.result = NewResult(a.interval, a.ips, a.clients, a.domains, a.sample, a.cache, a.hasSelfIps, a.group)
 }
 
-func (a *Analyzer) isRecursion(dl *types.Dnslog) bool {
-	_, ok := a.selfIps[dl.SrcIP.String()]
-	return ok && dl.DstPort == 53
+func (a *Analyzer) countCache(txs []*correlate.Transaction) {
+	if a.result.CacheEstimate == nil {
+		return
+	}
+
+	for _, tx := range txs {
+		if tx.Response == nil {
+			continue
+		}
+		hit, method := a.estimator.estimate(tx)
+		a.result.CacheEstimate.count(tx, hit, method)
+	}
+}
+
+func (a *Analyzer) ipGroups(dl *types.Dnslog) []string {
+	if a.ipTrie.Len() == 0 {
+		return nil
+	}
+
+	groups := []string{}
+	seen := map[string]struct{}{}
+	for _, g := range append(a.ipTrie.MatchIP(dl.SrcIP), a.ipTrie.MatchIP(dl.DstIP)...) {
+		if _, ok := seen[g]; ok {
+			continue
+		}
+		seen[g] = struct{}{}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// clientGroup returns the group of the client of the record, the longest
+// matching prefix wins when the groups overlap.
+func (a *Analyzer) clientGroup(dl *types.Dnslog) string {
+	if len(a.clients) == 0 {
+		return ""
+	}
+
+	client := dl.SrcIP
+	if dl.Response {
+		client = dl.DstIP
+	}
+	if g, ok := a.clientTrie.LookupIP(client); ok {
+		return g
+	}
+	return a.other
+}
+
+// domainGroups returns the analyze domain patterns matching the query name,
+// the domain group only applies to the top domains.
+func (a *Analyzer) domainGroups(dl *types.Dnslog) []string {
+	if a.domainTrie.Len() == 0 {
+		return nil
+	}
+
+	groups := []string{}
+	seen := map[string]struct{}{}
+	for _, g := range a.domainTrie.Match(dl.Domain) {
+		if _, ok := seen[g]; ok {
+			continue
+		}
+		seen[g] = struct{}{}
+		groups = append(groups, g)
+	}
+	return groups
 }
@@ -0,0 +1,84 @@
+package analyzer
+
+import (
+	"net"
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/pkg/domaintrie"
+	"github.com/hiwyw/dnscap-go/app/pkg/publicsuffix"
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+func TestDomainGroups(t *testing.T) {
+	a := &Analyzer{
+		domainTrie: domaintrie.New[string](),
+		group:      publicsuffix.Group{Registered: true},
+	}
+	for _, d := range []string{"www.example.com.", ".example.com."} {
+		a.domainTrie.Insert(d, d)
+	}
+
+	// the patterns see the query name, not its registered domain
+	dl := &types.Dnslog{Domain: "www.example.com.", RegisteredDomain: "example.com."}
+	if g := a.domainGroups(dl); !reflect.DeepEqual(g, []string{".example.com.", "www.example.com."}) {
+		t.Fatalf("unexpected domain groups %v", g)
+	}
+	dl = &types.Dnslog{Domain: "mail.example.com.", RegisteredDomain: "example.com."}
+	if g := a.domainGroups(dl); !reflect.DeepEqual(g, []string{".example.com."}) {
+		t.Fatalf("unexpected domain groups %v", g)
+	}
+}
+
+func TestAnalyzerClientGroups(t *testing.T) {
+	c := handler.NewClassifier([]netip.Prefix{netip.MustParsePrefix("10.0.0.53/32")}, []uint16{53}, false)
+	groups := map[string][]netip.Prefix{"office": {netip.MustParsePrefix("10.1.0.0/16")}}
+	a := New(filepath.Join(t.TempDir(), "analyze.log"), time.Minute, nil, groups, "rest", nil, publicsuffix.Group{}, c, 1, 5*time.Second, 0)
+
+	now := time.Unix(1700000000, 0)
+	resolver := net.ParseIP("10.0.0.53")
+	exchange := func(client string, port uint16, rcode string) {
+		ip := net.ParseIP(client)
+		a.analyze(&types.Dnslog{PacketTime: now, SrcIP: ip, DstIP: resolver, SrcPort: port, DstPort: 53, Domain: "www.example.com.", QueryType: "A"})
+		a.analyze(&types.Dnslog{PacketTime: now, SrcIP: resolver, DstIP: ip, SrcPort: 53, DstPort: port, Domain: "www.example.com.", QueryType: "A", Response: true, Rcode: rcode})
+	}
+	exchange("10.1.0.5", 40000, "NOERROR")
+	exchange("10.1.2.6", 40001, "NXDOMAIN")
+	exchange("192.0.2.7", 40002, "NOERROR")
+
+	if len(a.result.ClientGroupCounts) != 2 {
+		t.Fatalf("unexpected client groups %v", a.result.ClientGroupCounts)
+	}
+	office := a.result.ClientGroupCounts["office"]
+	if office.QueryCount != 2 || office.ResponseCount != 2 || office.RcodeCount["NXDOMAIN"] != 1 {
+		t.Fatalf("unexpected office count %+v", office)
+	}
+	rest := a.result.ClientGroupCounts["rest"]
+	if rest == nil || rest.QueryCount != 1 || rest.ResponseCount != 1 || rest.RcodeCount["NOERROR"] != 1 {
+		t.Fatalf("unexpected other group count %+v", rest)
+	}
+}
+
+func TestCountResultFirstDelay(t *testing.T) {
+	c := NewCountResult(false, false, 1)
+	c.count(&types.Dnslog{Response: true, ResolvDuration: 5 * time.Millisecond, FirstResolvDuration: 500 * time.Millisecond})
+	if c.DelayCount[delayLess10ms] != 1 || c.FirstDelayCount[delayLess10ms] != 0 || c.FirstDelayCount[delayLess1000ms] != 1 {
+		t.Fatalf("unexpected delay counts %v first %v", c.DelayCount, c.FirstDelayCount)
+	}
+}
+
+func TestCountDelayBuckets(t *testing.T) {
+	c := NewCountResult(false, false, 1)
+	for _, ms := range []int{5, 50, 500, 2000, 5000} {
+		c.countDelay(c.DelayCount, time.Duration(ms)*time.Millisecond)
+	}
+	for bucket, n := range c.DelayCount {
+		if n != 1 {
+			t.Fatalf("bucket %s should count 1 but %d", bucket, n)
+		}
+	}
+}
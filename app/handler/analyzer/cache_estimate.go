@@ -0,0 +1,187 @@
+package analyzer
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/hiwyw/dnscap-go/app/pkg/correlate"
+	"github.com/hiwyw/dnscap-go/app/pkg/publicsuffix"
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+const (
+	cacheMethodUpstream = "upstream"
+	cacheMethodTtl      = "ttl"
+	cacheMethodLatency  = "latency"
+
+	cacheTtlTrackSize = 100000
+	cacheTopDomains   = 10
+)
+
+func newCacheEstimator(hitLatency time.Duration) *cacheEstimator {
+	ttls, _ := lru.New(cacheTtlTrackSize)
+	return &cacheEstimator{
+		hitLatency: hitLatency,
+		maxTtls:    ttls,
+	}
+}
+
+// cacheEstimator decides whether a client transaction was answered from the
+// resolver cache. Correlated upstream queries are the strongest evidence; when
+// no recursion traffic is visible at all it falls back to the answer ttl being
+// lower than the largest ttl seen for the same rrset, and then to latency.
+type cacheEstimator struct {
+	hitLatency   time.Duration
+	upstreamSeen bool
+	maxTtls      *lru.Cache
+}
+
+func (e *cacheEstimator) observe(dl *types.Dnslog, isRecursion bool) {
+	if isRecursion {
+		e.upstreamSeen = true
+	}
+
+	if !dl.Response {
+		return
+	}
+
+	ttl, ok := answerTtl(dl)
+	if !ok {
+		return
+	}
+
+	k := cacheKey(dl)
+	if v, ok := e.maxTtls.Peek(k); ok && v.(uint32) >= ttl {
+		return
+	}
+	e.maxTtls.Add(k, ttl)
+}
+
+func (e *cacheEstimator) estimate(tx *correlate.Transaction) (bool, string) {
+	if tx.UpstreamQueries > 0 {
+		return false, cacheMethodUpstream
+	}
+
+	if e.upstreamSeen {
+		return true, cacheMethodUpstream
+	}
+
+	if ttl, ok := answerTtl(tx.Response); ok {
+		if v, ok := e.maxTtls.Peek(cacheKey(tx.Response)); ok && ttl < v.(uint32) {
+			return true, cacheMethodTtl
+		}
+	}
+
+	return tx.Response.ResolvDuration <= e.hitLatency, cacheMethodLatency
+}
+
+func cacheKey(dl *types.Dnslog) string {
+	return strings.ToLower(dl.Domain) + "|" + dl.QueryType
+}
+
+func answerTtl(dl *types.Dnslog) (uint32, bool) {
+	var min uint32
+	found := false
+	for _, rr := range dl.Answer {
+		if rr.Type != dl.QueryType {
+			continue
+		}
+		if !found || rr.Ttl < min {
+			min = rr.Ttl
+			found = true
+		}
+	}
+	return min, found
+}
+
+func NewCacheResult(weight int, group publicsuffix.Group) *CacheResult {
+	return &CacheResult{
+		MethodCount:    map[string]int{},
+		QueryTypeCount: map[string]*CacheCount{},
+		domainCount:    map[string]*CacheCount{},
+		weight:         weight,
+		group:          group,
+	}
+}
+
+type CacheResult struct {
+	CacheCount
+	MethodCount    map[string]int         `json:"method_statistics"`
+	QueryTypeCount map[string]*CacheCount `json:"qtype_statistics"`
+	TopDomains     []*DomainCacheCount    `json:"top_domains"`
+	domainCount    map[string]*CacheCount
+	weight         int
+	group          publicsuffix.Group
+}
+
+type CacheCount struct {
+	Hit      int     `json:"hit"`
+	Miss     int     `json:"miss"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+type DomainCacheCount struct {
+	Domain string `json:"domain"`
+	CacheCount
+}
+
+func (c *CacheCount) count(hit bool, weight int) {
+	if hit {
+		c.Hit += weight
+	} else {
+		c.Miss += weight
+	}
+}
+
+func (c *CacheCount) summarize() {
+	if c.Hit+c.Miss > 0 {
+		c.HitRatio = float64(c.Hit) / float64(c.Hit+c.Miss)
+	}
+}
+
+func (r *CacheResult) count(tx *correlate.Transaction, hit bool, method string) {
+	r.CacheCount.count(hit, r.weight)
+	r.MethodCount[method] += r.weight
+
+	qt, ok := r.QueryTypeCount[tx.Query.QueryType]
+	if !ok {
+		qt = &CacheCount{}
+		r.QueryTypeCount[tx.Query.QueryType] = qt
+	}
+	qt.count(hit, r.weight)
+
+	domain := strings.ToLower(r.group.Key(tx.Query.Domain, tx.Query.RegisteredDomain))
+	dc, ok := r.domainCount[domain]
+	if !ok {
+		dc = &CacheCount{}
+		r.domainCount[domain] = dc
+	}
+	dc.count(hit, r.weight)
+}
+
+func (r *CacheResult) summarize() {
+	r.CacheCount.summarize()
+	for _, c := range r.QueryTypeCount {
+		c.summarize()
+	}
+
+	top := []*DomainCacheCount{}
+	for d, c := range r.domainCount {
+		c.summarize()
+		top = append(top, &DomainCacheCount{Domain: d, CacheCount: *c})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		ti, tj := top[i].Hit+top[i].Miss, top[j].Hit+top[j].Miss
+		if ti != tj {
+			return ti > tj
+		}
+		return top[i].Domain < top[j].Domain
+	})
+	if len(top) > cacheTopDomains {
+		top = top[:cacheTopDomains]
+	}
+	r.TopDomains = top
+}
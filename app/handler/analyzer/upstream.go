@@ -0,0 +1,278 @@
+package analyzer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+const (
+	upstreamTop = 20
+)
+
+// upstreamTracker follows the recursion side queries of the observed resolver
+// until they are answered or time out. The zone of a query is approximated by
+// the registered domain of its name.
+type upstreamTracker struct {
+	timeout time.Duration
+	pending map[string]*upstreamQuery
+	queue   []*upstreamQuery
+	edns    map[string]*upstreamQuery
+}
+
+type upstreamQuery struct {
+	key     string
+	ednsKey string
+	server  string
+	zone    string
+	time    time.Time
+	edns    bool
+	failed  bool
+	done    bool
+}
+
+func newUpstreamTracker(timeout time.Duration) *upstreamTracker {
+	return &upstreamTracker{
+		timeout: timeout,
+		pending: map[string]*upstreamQuery{},
+		edns:    map[string]*upstreamQuery{},
+	}
+}
+
+func (t *upstreamTracker) observe(dl *types.Dnslog, r *UpstreamResult) {
+	if dl.Response {
+		t.response(dl, r)
+	} else {
+		t.query(dl, r)
+	}
+}
+
+func (t *upstreamTracker) query(dl *types.Dnslog, r *UpstreamResult) {
+	key := upstreamKey(dl.SrcIP.String(), dl.SrcPort, dl.DstIP.String(), dl.DstPort, dl.TransID)
+	if _, ok := t.pending[key]; ok {
+		return
+	}
+
+	q := &upstreamQuery{
+		key:     key,
+		ednsKey: fmt.Sprintf("%s|%s|%s", dl.DstIP, strings.ToLower(dl.Domain), dl.QueryType),
+		server:  dl.DstIP.String(),
+		zone:    upstreamZone(dl),
+		time:    dl.PacketTime,
+		edns:    dl.Edns,
+	}
+	t.pending[key] = q
+	t.queue = append(t.queue, q)
+	r.query(q.server, q.zone, q.edns)
+
+	// a plain query following an edns query for the same question that
+	// failed or is still outstanding is the resolver falling back
+	prev, ok := t.edns[q.ednsKey]
+	switch {
+	case q.edns:
+		t.edns[q.ednsKey] = q
+	case ok && (prev.failed || !prev.done):
+		r.fallback(q.server, q.zone)
+		delete(t.edns, q.ednsKey)
+	}
+}
+
+func (t *upstreamTracker) response(dl *types.Dnslog, r *UpstreamResult) {
+	key := upstreamKey(dl.DstIP.String(), dl.DstPort, dl.SrcIP.String(), dl.SrcPort, dl.TransID)
+	q, ok := t.pending[key]
+	if !ok {
+		r.response(dl.SrcIP.String(), upstreamZone(dl), dl.Rcode, dl.ResolvDuration, false)
+		return
+	}
+
+	q.done = true
+	delete(t.pending, key)
+
+	unsupported := q.edns && (!dl.Edns || dl.Rcode == dns.RcodeToString[dns.RcodeFormatError] || dl.Rcode == dns.RcodeToString[dns.RcodeNotImplemented])
+	q.failed = unsupported || dl.Rcode == dns.RcodeToString[dns.RcodeServerFailure]
+	r.response(q.server, q.zone, dl.Rcode, dl.PacketTime.Sub(q.time), unsupported)
+}
+
+// expire counts the queries unanswered for longer than the timeout, answered
+// queries are kept as long so that a fallback after them is still seen.
+func (t *upstreamTracker) expire(now time.Time, r *UpstreamResult) {
+	for len(t.queue) > 0 {
+		q := t.queue[0]
+		if !q.time.Add(t.timeout).Before(now) {
+			break
+		}
+		t.queue = t.queue[1:]
+		if t.edns[q.ednsKey] == q {
+			delete(t.edns, q.ednsKey)
+		}
+		if !q.done {
+			q.done = true
+			delete(t.pending, q.key)
+			r.timeout(q.server, q.zone)
+		}
+	}
+}
+
+func upstreamKey(client string, clientPort uint16, server string, serverPort uint16, id uint16) string {
+	return fmt.Sprintf("%s|%d|%s|%d|%d", client, clientPort, server, serverPort, id)
+}
+
+func upstreamZone(dl *types.Dnslog) string {
+	if dl.RegisteredDomain != "" {
+		return dl.RegisteredDomain
+	}
+	return strings.ToLower(dl.Domain)
+}
+
+func NewUpstreamResult(weight int) *UpstreamResult {
+	return &UpstreamResult{
+		servers: map[string]*UpstreamCount{},
+		zones:   map[string]*UpstreamCount{},
+		weight:  weight,
+	}
+}
+
+// UpstreamResult breaks the recursion side down by upstream server and by
+// zone, only the most failing of each are output so that a failing server is
+// not hidden behind busy healthy ones.
+type UpstreamResult struct {
+	Servers []*UpstreamCount `json:"servers"`
+	Zones   []*UpstreamCount `json:"zones"`
+	servers map[string]*UpstreamCount
+	zones   map[string]*UpstreamCount
+	weight  int
+}
+
+type UpstreamCount struct {
+	Name                 string         `json:"name"`
+	QueryCount           int            `json:"query_count"`
+	ResponseCount        int            `json:"response_count"`
+	TimeoutCount         int            `json:"timeout_count"`
+	TimeoutRate          float64        `json:"timeout_rate"`
+	RcodeCount           map[string]int `json:"rcode_statistics"`
+	Latency              *Percentiles   `json:"latency_ms,omitempty"`
+	EdnsQueryCount       int            `json:"edns_query_count"`
+	EdnsUnsupportedCount int            `json:"edns_unsupported_count"`
+	EdnsFallbackCount    int            `json:"edns_fallback_count"`
+	latencies            []time.Duration
+}
+
+type Percentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+func (r *UpstreamResult) each(server, zone string, fn func(c *UpstreamCount)) {
+	for _, m := range []struct {
+		counts map[string]*UpstreamCount
+		name   string
+	}{{r.servers, server}, {r.zones, zone}} {
+		c, ok := m.counts[m.name]
+		if !ok {
+			c = &UpstreamCount{Name: m.name, RcodeCount: map[string]int{}}
+			m.counts[m.name] = c
+		}
+		fn(c)
+	}
+}
+
+func (r *UpstreamResult) query(server, zone string, edns bool) {
+	r.each(server, zone, func(c *UpstreamCount) {
+		c.QueryCount += r.weight
+		if edns {
+			c.EdnsQueryCount += r.weight
+		}
+	})
+}
+
+func (r *UpstreamResult) response(server, zone, rcode string, latency time.Duration, ednsUnsupported bool) {
+	r.each(server, zone, func(c *UpstreamCount) {
+		c.ResponseCount += r.weight
+		c.RcodeCount[rcode] += r.weight
+		if latency > 0 {
+			c.latencies = append(c.latencies, latency)
+		}
+		if ednsUnsupported {
+			c.EdnsUnsupportedCount += r.weight
+		}
+	})
+}
+
+func (r *UpstreamResult) timeout(server, zone string) {
+	r.each(server, zone, func(c *UpstreamCount) {
+		c.TimeoutCount += r.weight
+	})
+}
+
+func (r *UpstreamResult) fallback(server, zone string) {
+	r.each(server, zone, func(c *UpstreamCount) {
+		c.EdnsFallbackCount += r.weight
+	})
+}
+
+func (r *UpstreamResult) summarize() {
+	r.Servers = topUpstreams(r.servers)
+	r.Zones = topUpstreams(r.zones)
+}
+
+func topUpstreams(counts map[string]*UpstreamCount) []*UpstreamCount {
+	top := []*UpstreamCount{}
+	for _, c := range counts {
+		top = append(top, c)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if fi, fj := top[i].failures(), top[j].failures(); fi != fj {
+			return fi > fj
+		}
+		if top[i].QueryCount != top[j].QueryCount {
+			return top[i].QueryCount > top[j].QueryCount
+		}
+		return top[i].Name < top[j].Name
+	})
+	if len(top) > upstreamTop {
+		top = top[:upstreamTop]
+	}
+
+	for _, c := range top {
+		if c.QueryCount > 0 {
+			c.TimeoutRate = float64(c.TimeoutCount) / float64(c.QueryCount)
+		}
+		c.Latency = percentiles(c.latencies)
+	}
+	return top
+}
+
+// failures counts the queries timed out or answered with SERVFAIL or REFUSED.
+func (c *UpstreamCount) failures() int {
+	return c.TimeoutCount + c.RcodeCount[dns.RcodeToString[dns.RcodeServerFailure]] + c.RcodeCount[dns.RcodeToString[dns.RcodeRefused]]
+}
+
+// percentiles returns the nearest rank percentiles in milliseconds.
+func percentiles(ds []time.Duration) *Percentiles {
+	if len(ds) == 0 {
+		return nil
+	}
+
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(ds)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return float64(ds[i].Microseconds()) / 1000
+	}
+	return &Percentiles{
+		P50: rank(0.5),
+		P90: rank(0.9),
+		P99: rank(0.99),
+		Max: rank(1),
+	}
+}
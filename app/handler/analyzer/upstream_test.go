@@ -0,0 +1,115 @@
+package analyzer
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+func upstreamPacket(at time.Time, server string, id uint16, name string, edns, response bool, rcode string) *types.Dnslog {
+	dl := &types.Dnslog{
+		PacketTime:       at,
+		SrcIP:            net.ParseIP("10.0.0.53"),
+		DstIP:            net.ParseIP(server),
+		SrcPort:          40000 + id,
+		DstPort:          53,
+		TransID:          id,
+		Domain:           name,
+		RegisteredDomain: "example.com.",
+		QueryType:        "A",
+		Edns:             edns,
+		Response:         response,
+		Rcode:            rcode,
+	}
+	if response {
+		dl.SrcIP, dl.DstIP = dl.DstIP, dl.SrcIP
+		dl.SrcPort, dl.DstPort = dl.DstPort, dl.SrcPort
+	}
+	return dl
+}
+
+func TestUpstreamTracker(t *testing.T) {
+	tr := newUpstreamTracker(time.Second)
+	r := NewUpstreamResult(1)
+	now := time.Unix(1700000000, 0)
+	feed := func(dl *types.Dnslog) {
+		tr.expire(dl.PacketTime, r)
+		tr.observe(dl, r)
+	}
+
+	// answered edns query
+	feed(upstreamPacket(now, "192.0.2.1", 1, "www.example.com.", true, false, ""))
+	feed(upstreamPacket(now.Add(20*time.Millisecond), "192.0.2.1", 1, "www.example.com.", true, true, "NOERROR"))
+
+	// edns query answered with FORMERR, then retried without edns
+	feed(upstreamPacket(now.Add(30*time.Millisecond), "192.0.2.2", 2, "a.example.com.", true, false, ""))
+	feed(upstreamPacket(now.Add(40*time.Millisecond), "192.0.2.2", 2, "a.example.com.", true, true, "FORMERR"))
+	feed(upstreamPacket(now.Add(50*time.Millisecond), "192.0.2.2", 3, "a.example.com.", false, false, ""))
+	feed(upstreamPacket(now.Add(60*time.Millisecond), "192.0.2.2", 3, "a.example.com.", false, true, "NOERROR"))
+
+	// edns query never answered and a plain query fallback before the timeout
+	feed(upstreamPacket(now.Add(100*time.Millisecond), "192.0.2.3", 4, "b.example.com.", true, false, ""))
+	feed(upstreamPacket(now.Add(900*time.Millisecond), "192.0.2.3", 5, "b.example.com.", false, false, ""))
+	feed(upstreamPacket(now.Add(950*time.Millisecond), "192.0.2.3", 5, "b.example.com.", false, true, "NOERROR"))
+	tr.expire(now.Add(3*time.Second), r)
+	r.summarize()
+
+	servers := map[string]*UpstreamCount{}
+	for _, c := range r.Servers {
+		servers[c.Name] = c
+	}
+
+	if c := servers["192.0.2.1"]; c.QueryCount != 1 || c.ResponseCount != 1 || c.Latency == nil || c.Latency.P50 != 20 {
+		t.Fatalf("answered server stats wrong %+v", c)
+	}
+	if c := servers["192.0.2.2"]; c.EdnsUnsupportedCount != 1 || c.EdnsFallbackCount != 1 || c.RcodeCount["FORMERR"] != 1 || c.TimeoutCount != 0 {
+		t.Fatalf("formerr server stats wrong %+v", c)
+	}
+	if c := servers["192.0.2.3"]; c.QueryCount != 2 || c.TimeoutCount != 1 || c.TimeoutRate != 0.5 || c.EdnsFallbackCount != 1 {
+		t.Fatalf("timeout server stats wrong %+v", c)
+	}
+
+	if len(r.Zones) != 1 || r.Zones[0].Name != "example.com." || r.Zones[0].QueryCount != 5 || r.Zones[0].EdnsQueryCount != 3 {
+		t.Fatalf("zone stats wrong %+v", r.Zones)
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	ds := []time.Duration{}
+	for i := 100; i >= 1; i-- {
+		ds = append(ds, time.Duration(i)*time.Millisecond)
+	}
+	p := percentiles(ds)
+	if p.P50 != 50 || p.P90 != 90 || p.P99 != 99 || p.Max != 100 {
+		t.Fatalf("percentiles wrong %+v", p)
+	}
+	if percentiles(nil) != nil {
+		t.Fatalf("empty percentiles should be nil")
+	}
+}
+
+func TestTopUpstreamsFailingFirst(t *testing.T) {
+	r := NewUpstreamResult(1)
+	for i := 0; i < upstreamTop+5; i++ {
+		server := fmt.Sprintf("192.0.2.%d", i+1)
+		for j := 0; j < 100; j++ {
+			r.query(server, "example.com.", false)
+			r.response(server, "example.com.", "NOERROR", time.Millisecond, false)
+		}
+	}
+	r.query("198.51.100.1", "example.net.", false)
+	r.timeout("198.51.100.1", "example.net.")
+	r.query("198.51.100.2", "example.org.", false)
+	r.response("198.51.100.2", "example.org.", "SERVFAIL", time.Millisecond, false)
+	r.summarize()
+
+	if len(r.Servers) != upstreamTop || r.Servers[0].Name != "198.51.100.1" || r.Servers[1].Name != "198.51.100.2" {
+		t.Fatalf("failing servers should rank first %+v", r.Servers[:2])
+	}
+	if r.Servers[0].TimeoutRate != 1 {
+		t.Fatalf("timeout rate should be 1 but %f", r.Servers[0].TimeoutRate)
+	}
+}
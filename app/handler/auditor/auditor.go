@@ -0,0 +1,159 @@
+package auditor
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/natefinch/lumberjack"
+)
+
+const (
+	taskChannelBuffer = 100
+	allUpstreams      = "all"
+)
+
+// New audits the source port, transid and qname case randomness of the
+// queries the resolver sends upstream, and writes a report per interval.
+func New(filename string, interval time.Duration, minQueries int, classifier *handler.Classifier) *Auditor {
+	if !classifier.HasSelfIps() {
+		logger.Warnf("self ips empty, no recursion queries to audit")
+	}
+
+	a := &Auditor{
+		classifier: classifier,
+		interval:   interval,
+		minQueries: minQueries,
+		writer: &lumberjack.Logger{
+			Filename:   filename,
+			MaxSize:    50,
+			MaxBackups: 10,
+			MaxAge:     100,
+			Compress:   true,
+		},
+		taskCh:  make(chan *types.Dnslog, taskChannelBuffer),
+		closeCh: make(chan struct{}),
+	}
+	a.reset()
+
+	go a.loop()
+	return a
+}
+
+type Auditor struct {
+	begin      bool
+	endTime    time.Time
+	classifier *handler.Classifier
+	interval   time.Duration
+	minQueries int
+	all        *collector
+	upstreams  map[string]*collector
+	writer     *lumberjack.Logger
+	taskCh     chan *types.Dnslog
+	closeCh    chan struct{}
+}
+
+type Report struct {
+	BeginTime time.Time `json:"begin_time"`
+	EndTime   time.Time `json:"end_time"`
+	Result    string    `json:"result"`
+	Total     *Stats    `json:"total"`
+	Upstreams []*Stats  `json:"upstreams"`
+}
+
+func (a *Auditor) Handle(dl *types.Dnslog) {
+	a.taskCh <- dl
+}
+
+func (a *Auditor) Stop() {
+	close(a.taskCh)
+	<-a.closeCh
+	a.writer.Close()
+}
+
+func (a *Auditor) loop() {
+	for {
+		dl, ok := <-a.taskCh
+		if !ok {
+			a.out()
+			a.closeCh <- struct{}{}
+			logger.Infof("auditor handler exiting")
+			return
+		}
+		a.audit(dl)
+	}
+}
+
+func (a *Auditor) audit(dl *types.Dnslog) {
+	if !a.begin {
+		a.endTime = dl.PacketTime.Add(a.interval)
+		a.begin = true
+	}
+
+	if dl.PacketTime.After(a.endTime) {
+		a.out()
+		a.endTime = a.endTime.Add(a.interval)
+	}
+
+	if dl.Response || !a.classifier.IsRecursion(dl) {
+		return
+	}
+
+	upstream := dl.DstIP.String()
+	c, ok := a.upstreams[upstream]
+	if !ok {
+		c = newCollector(upstream)
+		a.upstreams[upstream] = c
+	}
+	c.add(dl.SrcPort, dl.TransID, dl.Domain)
+	a.all.add(dl.SrcPort, dl.TransID, dl.Domain)
+}
+
+func (a *Auditor) reset() {
+	a.all = newCollector(allUpstreams)
+	a.upstreams = map[string]*collector{}
+}
+
+func (a *Auditor) report() *Report {
+	r := &Report{
+		BeginTime: a.endTime.Local().Add(-a.interval),
+		EndTime:   a.endTime.Local(),
+		Total:     a.all.stats(a.minQueries),
+		Upstreams: []*Stats{},
+	}
+
+	results := []string{r.Total.Result}
+	for _, c := range a.upstreams {
+		s := c.stats(a.minQueries)
+		r.Upstreams = append(r.Upstreams, s)
+		results = append(results, s.Result)
+	}
+	sort.Slice(r.Upstreams, func(i, j int) bool {
+		if r.Upstreams[i].Queries != r.Upstreams[j].Queries {
+			return r.Upstreams[i].Queries > r.Upstreams[j].Queries
+		}
+		return r.Upstreams[i].Upstream < r.Upstreams[j].Upstream
+	})
+	r.Result = worst(results...)
+	return r
+}
+
+func (a *Auditor) out() {
+	if !a.begin {
+		return
+	}
+	defer a.reset()
+
+	b, err := json.Marshal(a.report())
+	if err != nil {
+		logger.Errorf("audit report marshal to json failed %s", err)
+		return
+	}
+
+	if _, err := a.writer.Write(append(b, '\n')); err != nil {
+		logger.Errorf("write file %s failed %s", a.writer.Filename, err)
+	}
+}
@@ -0,0 +1,222 @@
+package auditor
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	ResultPass         = "pass"
+	ResultWarn         = "warn"
+	ResultFail         = "fail"
+	ResultInsufficient = "insufficient"
+
+	idSpace         = 65536
+	recentPorts     = 16
+	minCaseLetters  = 2
+	passEntropyRate = 0.9
+	failEntropyRate = 0.5
+	passPortSpan    = 10000
+	warnReuseRate   = 0.05
+	failReuseRate   = 0.5
+	passCaseRate    = 0.5
+)
+
+// Stats is the randomness report of the recursion queries sent to one
+// upstream, or to all upstreams, within an interval.
+type Stats struct {
+	Upstream         string  `json:"upstream"`
+	Queries          int     `json:"queries"`
+	DistinctPorts    int     `json:"distinct_ports"`
+	PortSpan         int     `json:"port_span"`
+	PortEntropy      float64 `json:"port_entropy"`
+	PortEntropyRate  float64 `json:"port_entropy_rate"`
+	PortReuseRate    float64 `json:"port_reuse_rate"`
+	DistinctIds      int     `json:"distinct_transids"`
+	IdEntropy        float64 `json:"transid_entropy"`
+	IdEntropyRate    float64 `json:"transid_entropy_rate"`
+	IdSequentialRate float64 `json:"transid_sequential_rate"`
+	CaseChecked      int     `json:"case_checked"`
+	CaseRandomRate   float64 `json:"case_random_rate"`
+	PortResult       string  `json:"port_result"`
+	IdResult         string  `json:"transid_result"`
+	CaseResult       string  `json:"case_result"`
+	Result           string  `json:"result"`
+}
+
+func newCollector(upstream string) *collector {
+	return &collector{
+		upstream: upstream,
+		ports:    map[uint16]int{},
+		ids:      map[uint16]int{},
+	}
+}
+
+// collector counts the source ports, transids and qname letter case of the
+// queries in sending order.
+type collector struct {
+	upstream   string
+	queries    int
+	ports      map[uint16]int
+	ids        map[uint16]int
+	recent     []uint16
+	reused     int
+	lastId     uint16
+	sequential int
+	caseCheck  int
+	caseRandom int
+}
+
+func (c *collector) add(port, id uint16, qname string) {
+	for _, p := range c.recent {
+		if p == port {
+			c.reused++
+			break
+		}
+	}
+	c.recent = append(c.recent, port)
+	if len(c.recent) > recentPorts {
+		c.recent = c.recent[1:]
+	}
+
+	if c.queries > 0 && id == c.lastId+1 {
+		c.sequential++
+	}
+	c.lastId = id
+
+	c.queries++
+	c.ports[port]++
+	c.ids[id]++
+
+	if upper, lower := letterCase(qname); upper+lower >= minCaseLetters {
+		c.caseCheck++
+		if upper > 0 && lower > 0 {
+			c.caseRandom++
+		}
+	}
+}
+
+func (c *collector) stats(minQueries int) *Stats {
+	s := &Stats{
+		Upstream:      c.upstream,
+		Queries:       c.queries,
+		DistinctPorts: len(c.ports),
+		DistinctIds:   len(c.ids),
+		CaseChecked:   c.caseCheck,
+	}
+	if c.queries == 0 {
+		s.PortResult, s.IdResult, s.CaseResult, s.Result = ResultInsufficient, ResultInsufficient, ResultInsufficient, ResultInsufficient
+		return s
+	}
+
+	// n queries carry at most log2(n) bits however random they are
+	maxEntropy := math.Log2(math.Min(float64(c.queries), idSpace))
+
+	s.PortSpan = span(c.ports)
+	s.PortEntropy = entropy(c.ports, c.queries)
+	s.PortEntropyRate = rate(s.PortEntropy, maxEntropy)
+	s.PortReuseRate = rate(float64(c.reused), float64(c.queries))
+	s.IdEntropy = entropy(c.ids, c.queries)
+	s.IdEntropyRate = rate(s.IdEntropy, maxEntropy)
+	s.IdSequentialRate = rate(float64(c.sequential), float64(c.queries-1))
+	s.CaseRandomRate = rate(float64(c.caseRandom), float64(c.caseCheck))
+
+	if c.queries < minQueries {
+		s.PortResult, s.IdResult, s.CaseResult, s.Result = ResultInsufficient, ResultInsufficient, ResultInsufficient, ResultInsufficient
+		return s
+	}
+
+	switch {
+	case s.DistinctPorts == 1 || s.PortEntropyRate < failEntropyRate || s.PortReuseRate > failReuseRate:
+		s.PortResult = ResultFail
+	case s.PortEntropyRate < passEntropyRate || s.PortSpan < passPortSpan || s.PortReuseRate > warnReuseRate:
+		s.PortResult = ResultWarn
+	default:
+		s.PortResult = ResultPass
+	}
+
+	switch {
+	case s.IdEntropyRate < failEntropyRate || s.IdSequentialRate > failReuseRate:
+		s.IdResult = ResultFail
+	case s.IdEntropyRate < passEntropyRate || s.IdSequentialRate > warnReuseRate:
+		s.IdResult = ResultWarn
+	default:
+		s.IdResult = ResultPass
+	}
+
+	// 0x20 is an additional defence many resolvers leave off, so it never fails
+	switch {
+	case c.caseCheck < minQueries:
+		s.CaseResult = ResultInsufficient
+	case s.CaseRandomRate < passCaseRate:
+		s.CaseResult = ResultWarn
+	default:
+		s.CaseResult = ResultPass
+	}
+
+	s.Result = worst(s.PortResult, s.IdResult, s.CaseResult)
+	return s
+}
+
+func worst(results ...string) string {
+	order := map[string]int{ResultInsufficient: 0, ResultPass: 1, ResultWarn: 2, ResultFail: 3}
+	w := ResultInsufficient
+	for _, r := range results {
+		if order[r] > order[w] {
+			w = r
+		}
+	}
+	return w
+}
+
+func letterCase(name string) (upper, lower int) {
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c >= 'A' && c <= 'Z':
+			upper++
+		case c >= 'a' && c <= 'z':
+			lower++
+		}
+	}
+	return
+}
+
+func entropy(counts map[uint16]int, total int) float64 {
+	// sorted so that the float sum does not depend on map order
+	cs := make([]int, 0, len(counts))
+	for _, c := range counts {
+		cs = append(cs, c)
+	}
+	sort.Ints(cs)
+
+	e := 0.0
+	for _, c := range cs {
+		p := float64(c) / float64(total)
+		e -= p * math.Log2(p)
+	}
+	return round(e)
+}
+
+func span(counts map[uint16]int) int {
+	min, max := -1, -1
+	for v := range counts {
+		if min < 0 || int(v) < min {
+			min = int(v)
+		}
+		if int(v) > max {
+			max = int(v)
+		}
+	}
+	return max - min + 1
+}
+
+func rate(a, b float64) float64 {
+	if b <= 0 {
+		return 0
+	}
+	return round(a / b)
+}
+
+func round(f float64) float64 {
+	return math.Round(f*10000) / 10000
+}
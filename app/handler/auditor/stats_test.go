@@ -0,0 +1,60 @@
+package auditor
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestStatsRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	c := newCollector("192.0.2.1")
+	for i := 0; i < 2000; i++ {
+		name := []byte("www.example.com.")
+		for j := range name {
+			if r.Intn(2) == 0 {
+				name[j] = strings.ToUpper(string(name[j]))[0]
+			}
+		}
+		c.add(uint16(1024+r.Intn(64512)), uint16(r.Intn(65536)), string(name))
+	}
+
+	s := c.stats(100)
+	if s.PortResult != ResultPass || s.IdResult != ResultPass || s.CaseResult != ResultPass || s.Result != ResultPass {
+		t.Fatalf("random queries should pass %+v", s)
+	}
+}
+
+func TestStatsWeak(t *testing.T) {
+	c := newCollector("192.0.2.1")
+	for i := 0; i < 2000; i++ {
+		c.add(53000, uint16(1000+i), "www.example.com.")
+	}
+
+	s := c.stats(100)
+	if s.PortResult != ResultFail || s.PortReuseRate != 0.9995 {
+		t.Fatalf("fixed port should fail %+v", s)
+	}
+	if s.IdResult != ResultFail || s.IdSequentialRate != 1 {
+		t.Fatalf("sequential transid should fail %+v", s)
+	}
+	if s.CaseResult != ResultWarn || s.Result != ResultFail {
+		t.Fatalf("unexpected results %+v", s)
+	}
+}
+
+func TestStatsPortPool(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	c := newCollector("192.0.2.1")
+	for i := 0; i < 5000; i++ {
+		c.add(uint16(49152+r.Intn(2500)), uint16(r.Intn(65536)), "a.")
+	}
+
+	s := c.stats(100)
+	if s.PortResult != ResultWarn || s.IdResult != ResultPass || s.CaseResult != ResultInsufficient || s.Result != ResultWarn {
+		t.Fatalf("small port pool should warn %+v", s)
+	}
+	if s := c.stats(10000); s.Result != ResultInsufficient {
+		t.Fatalf("too few queries should be insufficient %+v", s)
+	}
+}
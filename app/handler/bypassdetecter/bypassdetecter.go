@@ -0,0 +1,108 @@
+package bypassdetecter
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/natefinch/lumberjack"
+)
+
+const (
+	taskChannelBuffer = 100
+)
+
+// New writes the per client bypass report every interval of packet time.
+func New(filename string, interval time.Duration, classifier *handler.Classifier, dnsPorts []uint16, endpoints []Endpoint) *BypassDetecter {
+	if !classifier.HasSelfIps() {
+		logger.Warnf("self ips empty, all dns flows reported as resolver bypass")
+	}
+
+	b := &BypassDetecter{
+		detector: NewDetector(classifier, dnsPorts, endpoints),
+		interval: interval,
+		writer: &lumberjack.Logger{
+			Filename:   filename,
+			MaxSize:    50,
+			MaxBackups: 10,
+			MaxAge:     100,
+			Compress:   true,
+		},
+		taskCh:  make(chan *types.Flow, taskChannelBuffer),
+		closeCh: make(chan struct{}),
+	}
+
+	go b.loop()
+	return b
+}
+
+type BypassDetecter struct {
+	begin    bool
+	endTime  time.Time
+	detector *Detector
+	interval time.Duration
+	writer   *lumberjack.Logger
+	taskCh   chan *types.Flow
+	closeCh  chan struct{}
+}
+
+type Report struct {
+	BeginTime time.Time `json:"begin_time"`
+	EndTime   time.Time `json:"end_time"`
+	Clients   []*Client `json:"clients"`
+}
+
+func (b *BypassDetecter) HandleFlow(f *types.Flow) {
+	b.taskCh <- f
+}
+
+func (b *BypassDetecter) Stop() {
+	close(b.taskCh)
+	<-b.closeCh
+	b.writer.Close()
+}
+
+func (b *BypassDetecter) loop() {
+	for {
+		f, ok := <-b.taskCh
+		if !ok {
+			b.out()
+			b.closeCh <- struct{}{}
+			logger.Infof("bypass detecter handler exiting")
+			return
+		}
+
+		if !b.begin {
+			b.endTime = f.PacketTime.Add(b.interval)
+			b.begin = true
+		}
+		if f.PacketTime.After(b.endTime) {
+			b.out()
+			b.endTime = b.endTime.Add(b.interval)
+		}
+		b.detector.Add(f)
+	}
+}
+
+func (b *BypassDetecter) out() {
+	if !b.begin {
+		return
+	}
+
+	r := &Report{
+		BeginTime: b.endTime.Local().Add(-b.interval),
+		EndTime:   b.endTime.Local(),
+		Clients:   b.detector.Report(),
+	}
+	bs, err := json.Marshal(r)
+	if err != nil {
+		logger.Errorf("bypass report marshal to json failed %s", err)
+		return
+	}
+
+	if _, err := b.writer.Write(append(bs, '\n')); err != nil {
+		logger.Errorf("write file %s failed %s", b.writer.Filename, err)
+	}
+}
@@ -0,0 +1,173 @@
+package bypassdetecter
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+const (
+	ProtocolDns = "dns"
+	ProtocolDot = "dot"
+	ProtocolDoh = "doh"
+
+	DotPort = 853
+	DohPort = 443
+)
+
+type Client struct {
+	Client       string         `json:"client"`
+	Packets      int            `json:"packets"`
+	Bytes        int            `json:"bytes"`
+	Destinations []*Destination `json:"destinations"`
+	destinations map[string]*Destination
+}
+
+// Destination counts the packets of one client to a server, Packets are
+// sent by the client and ResponsePackets by the server.
+type Destination struct {
+	Server          string    `json:"server"`
+	Protocol        string    `json:"protocol"`
+	Transport       string    `json:"transport"`
+	Endpoint        string    `json:"endpoint,omitempty"`
+	Packets         int       `json:"packets"`
+	ResponsePackets int       `json:"response_packets"`
+	Bytes           int       `json:"bytes"`
+	Connections     int       `json:"connections"`
+	FirstSeen       time.Time `json:"first_seen"`
+	LastSeen        time.Time `json:"last_seen"`
+}
+
+// NewDetector counts the dns flows between clients and servers other than
+// self ips. Dns ports carry plain dns, port 853 dns over tls or quic and
+// port 443 to one of the endpoints dns over https.
+func NewDetector(classifier *handler.Classifier, dnsPorts []uint16, endpoints []Endpoint) *Detector {
+	d := &Detector{
+		classifier: classifier,
+		dnsPorts:   map[uint16]struct{}{},
+		endpoints:  iptrie.New[string](),
+		clients:    map[string]*Client{},
+	}
+
+	for _, p := range dnsPorts {
+		d.dnsPorts[p] = struct{}{}
+	}
+	for _, e := range endpoints {
+		d.endpoints.Insert(e.Prefix, e.Name)
+	}
+	return d
+}
+
+type Detector struct {
+	classifier *handler.Classifier
+	dnsPorts   map[uint16]struct{}
+	endpoints  *iptrie.Trie[string]
+	clients    map[string]*Client
+}
+
+type flowSide struct {
+	client   net.IP
+	server   net.IP
+	toServer bool
+	protocol string
+	endpoint string
+}
+
+// Add counts the flow if it is dns traffic bypassing self ips.
+func (d *Detector) Add(f *types.Flow) bool {
+	s, ok := d.classify(f)
+	if !ok || d.classifier.IsSelf(s.server) || d.classifier.IsSelf(s.client) {
+		return false
+	}
+
+	c, ok := d.clients[s.client.String()]
+	if !ok {
+		c = &Client{Client: s.client.String(), destinations: map[string]*Destination{}}
+		d.clients[c.Client] = c
+	}
+
+	k := s.server.String() + "|" + s.protocol + "|" + f.Transport
+	dest, ok := c.destinations[k]
+	if !ok {
+		dest = &Destination{
+			Server:    s.server.String(),
+			Protocol:  s.protocol,
+			Transport: f.Transport,
+			Endpoint:  s.endpoint,
+			FirstSeen: f.PacketTime,
+		}
+		c.destinations[k] = dest
+	}
+
+	if s.toServer {
+		dest.Packets++
+		c.Packets++
+		if f.Syn {
+			dest.Connections++
+		}
+	} else {
+		dest.ResponsePackets++
+	}
+	dest.Bytes += f.Length
+	c.Bytes += f.Length
+	dest.LastSeen = f.PacketTime
+	return true
+}
+
+func (d *Detector) classify(f *types.Flow) (*flowSide, bool) {
+	_, dstDns := d.dnsPorts[f.DstPort]
+	_, srcDns := d.dnsPorts[f.SrcPort]
+
+	switch {
+	case dstDns:
+		return &flowSide{client: f.SrcIP, server: f.DstIP, toServer: true, protocol: ProtocolDns}, true
+	case srcDns:
+		return &flowSide{client: f.DstIP, server: f.SrcIP, protocol: ProtocolDns}, true
+	case f.DstPort == DotPort:
+		return &flowSide{client: f.SrcIP, server: f.DstIP, toServer: true, protocol: ProtocolDot}, true
+	case f.SrcPort == DotPort:
+		return &flowSide{client: f.DstIP, server: f.SrcIP, protocol: ProtocolDot}, true
+	}
+
+	if f.DstPort == DohPort {
+		if name, ok := d.endpoints.LookupIP(f.DstIP); ok {
+			return &flowSide{client: f.SrcIP, server: f.DstIP, toServer: true, protocol: ProtocolDoh, endpoint: name}, true
+		}
+	}
+	if f.SrcPort == DohPort {
+		if name, ok := d.endpoints.LookupIP(f.SrcIP); ok {
+			return &flowSide{client: f.DstIP, server: f.SrcIP, protocol: ProtocolDoh, endpoint: name}, true
+		}
+	}
+	return nil, false
+}
+
+// Report returns the clients sorted by packets sent and starts counting over.
+func (d *Detector) Report() []*Client {
+	clients := []*Client{}
+	for _, c := range d.clients {
+		for _, dest := range c.destinations {
+			c.Destinations = append(c.Destinations, dest)
+		}
+		sort.Slice(c.Destinations, func(i, j int) bool {
+			if c.Destinations[i].Packets != c.Destinations[j].Packets {
+				return c.Destinations[i].Packets > c.Destinations[j].Packets
+			}
+			return c.Destinations[i].Server < c.Destinations[j].Server
+		})
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Packets != clients[j].Packets {
+			return clients[i].Packets > clients[j].Packets
+		}
+		return clients[i].Client < clients[j].Client
+	})
+
+	d.clients = map[string]*Client{}
+	return clients
+}
@@ -0,0 +1,87 @@
+package bypassdetecter
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/hiwyw/dnscap-go/app/types/dnslogtest"
+)
+
+func flow(src string, sport uint16, dst string, dport uint16, transport string, syn bool) *types.Flow {
+	return &types.Flow{
+		PacketTime: dnslogtest.Base,
+		SrcIP:      net.ParseIP(src),
+		DstIP:      net.ParseIP(dst),
+		SrcPort:    sport,
+		DstPort:    dport,
+		Transport:  transport,
+		Length:     100,
+		Syn:        syn,
+	}
+}
+
+func TestLoadEndpoints(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "doh.txt")
+	content := "# public doh\n8.8.8.8 dns.google\n1.1.1.0/24 # cloudflare\n9.9.9.9-9.9.9.10 quad9\n"
+	if err := os.WriteFile(fp, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints, err := LoadEndpoints(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(endpoints) != 4 || endpoints[0].Name != "dns.google" || endpoints[1].Name != "1.1.1.0/24" || endpoints[3].Prefix != netip.MustParsePrefix("9.9.9.10/32") {
+		t.Fatalf("unexpected endpoints %v", endpoints)
+	}
+}
+
+func TestDetector(t *testing.T) {
+	classifier := handler.NewClassifier([]netip.Prefix{netip.MustParsePrefix("10.0.0.53/32")}, []uint16{53}, false)
+	endpoints := []Endpoint{{Name: "dns.google", Prefix: netip.MustParsePrefix("8.8.8.8/32")}}
+	d := NewDetector(classifier, []uint16{53}, endpoints)
+
+	cases := []struct {
+		f      *types.Flow
+		bypass bool
+	}{
+		{flow("10.1.1.1", 40000, "10.0.0.53", 53, types.TransportUdp, false), false},
+		{flow("10.0.0.53", 40000, "198.51.100.1", 53, types.TransportUdp, false), false},
+		{flow("10.1.1.1", 40000, "8.8.4.4", 53, types.TransportUdp, false), true},
+		{flow("8.8.4.4", 53, "10.1.1.1", 40000, types.TransportUdp, false), true},
+		{flow("10.1.1.1", 40001, "1.1.1.1", 853, types.TransportTcp, true), true},
+		{flow("10.1.1.1", 40001, "1.1.1.1", 853, types.TransportTcp, false), true},
+		{flow("10.1.1.1", 40002, "8.8.8.8", 443, types.TransportTcp, true), true},
+		{flow("10.1.1.1", 40003, "192.0.2.1", 443, types.TransportTcp, true), false},
+		{flow("10.2.2.2", 40004, "8.8.8.8", 443, types.TransportUdp, false), true},
+	}
+	for i, c := range cases {
+		if d.Add(c.f) != c.bypass {
+			t.Fatalf("case %d should be bypass %v", i, c.bypass)
+		}
+	}
+
+	clients := d.Report()
+	if len(clients) != 2 || clients[0].Client != "10.1.1.1" || clients[0].Packets != 4 || clients[0].Bytes != 500 {
+		t.Fatalf("unexpected clients %+v", clients)
+	}
+
+	dests := clients[0].Destinations
+	if len(dests) != 3 || dests[0].Server != "1.1.1.1" || dests[0].Protocol != ProtocolDot || dests[0].Connections != 1 {
+		t.Fatalf("unexpected dot destination %+v", dests[0])
+	}
+	if dests[1].Server != "8.8.4.4" || dests[1].Packets != 1 || dests[1].ResponsePackets != 1 {
+		t.Fatalf("unexpected dns destination %+v", dests[1])
+	}
+	if dests[2].Protocol != ProtocolDoh || dests[2].Endpoint != "dns.google" {
+		t.Fatalf("unexpected doh destination %+v", dests[2])
+	}
+	if len(d.Report()) != 0 {
+		t.Fatalf("report should start counting over")
+	}
+}
@@ -0,0 +1,64 @@
+package bypassdetecter
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+)
+
+// Endpoint is an address block serving dns over https, Name is the label
+// reported for flows to it.
+type Endpoint struct {
+	Name   string
+	Prefix netip.Prefix
+}
+
+// LoadEndpoints reads one address, cidr or address range per line, optionally
+// followed by a name such as dns.google. Text after # is a comment.
+func LoadEndpoints(path string) ([]Endpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open doh endpoint list %s failed %s", path, err)
+	}
+	defer f.Close()
+
+	endpoints := []Endpoint{}
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fs := strings.Fields(line)
+		if len(fs) == 0 {
+			continue
+		}
+
+		prefixes, err := iptrie.Parse(fs[0])
+		if err != nil {
+			return nil, fmt.Errorf("doh endpoint list %s line %d %s", path, n, err)
+		}
+		name := fs[0]
+		if len(fs) > 1 {
+			name = fs[1]
+		}
+		for _, p := range prefixes {
+			endpoints = append(endpoints, Endpoint{Name: name, Prefix: p})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read doh endpoint list %s failed %s", path, err)
+	}
+	return endpoints, nil
+}
+
+func Prefixes(endpoints []Endpoint) []netip.Prefix {
+	prefixes := []netip.Prefix{}
+	for _, e := range endpoints {
+		prefixes = append(prefixes, e.Prefix)
+	}
+	return prefixes
+}
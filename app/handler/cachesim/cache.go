@@ -0,0 +1,198 @@
+package cachesim
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+	"time"
+)
+
+const (
+	PolicyLRU  = "lru"
+	PolicyLFU  = "lfu"
+	PolicyFIFO = "fifo"
+)
+
+type cache interface {
+	get(key string, now time.Time) (hit, expired bool)
+	set(key string, expire time.Time, size int) (evicted bool)
+	bytes() int
+}
+
+func newCache(policy string, capacity int) (cache, error) {
+	switch policy {
+	case PolicyLRU:
+		return newListCache(capacity, true), nil
+	case PolicyFIFO:
+		return newListCache(capacity, false), nil
+	case PolicyLFU:
+		return newLfuCache(capacity), nil
+	}
+	return nil, fmt.Errorf("unknown cache policy %s", policy)
+}
+
+type entry struct {
+	key    string
+	expire time.Time
+	size   int
+	freq   int
+	seq    int
+	index  int
+}
+
+func newListCache(capacity int, touch bool) *listCache {
+	return &listCache{
+		capacity: capacity,
+		touch:    touch,
+		items:    map[string]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+// listCache keeps entries in a list ordered by recency when touch is set (lru)
+// or by insertion otherwise (fifo) and evicts from the back.
+type listCache struct {
+	capacity int
+	touch    bool
+	items    map[string]*list.Element
+	order    *list.List
+	size     int
+}
+
+func (c *listCache) get(key string, now time.Time) (bool, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return false, false
+	}
+
+	e := el.Value.(*entry)
+	if !now.Before(e.expire) {
+		c.remove(el)
+		return false, true
+	}
+
+	if c.touch {
+		c.order.MoveToFront(el)
+	}
+	return true, false
+}
+
+func (c *listCache) set(key string, expire time.Time, size int) bool {
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+
+	evicted := false
+	if c.order.Len() >= c.capacity {
+		c.remove(c.order.Back())
+		evicted = true
+	}
+
+	c.items[key] = c.order.PushFront(&entry{key: key, expire: expire, size: size})
+	c.size += size
+	return evicted
+}
+
+func (c *listCache) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	c.order.Remove(el)
+	delete(c.items, e.key)
+	c.size -= e.size
+}
+
+func (c *listCache) bytes() int {
+	return c.size
+}
+
+func newLfuCache(capacity int) *lfuCache {
+	return &lfuCache{
+		capacity: capacity,
+		items:    map[string]*entry{},
+	}
+}
+
+// lfuCache evicts the least frequently used entry, the oldest one on ties.
+type lfuCache struct {
+	capacity int
+	items    map[string]*entry
+	heap     entryHeap
+	seq      int
+	size     int
+}
+
+func (c *lfuCache) get(key string, now time.Time) (bool, bool) {
+	e, ok := c.items[key]
+	if !ok {
+		return false, false
+	}
+
+	if !now.Before(e.expire) {
+		c.remove(e)
+		return false, true
+	}
+
+	e.freq++
+	heap.Fix(&c.heap, e.index)
+	return true, false
+}
+
+func (c *lfuCache) set(key string, expire time.Time, size int) bool {
+	freq := 1
+	if e, ok := c.items[key]; ok {
+		freq = e.freq + 1
+		c.remove(e)
+	}
+
+	evicted := false
+	if len(c.heap) >= c.capacity {
+		c.remove(c.heap[0])
+		evicted = true
+	}
+
+	c.seq++
+	e := &entry{key: key, expire: expire, size: size, freq: freq, seq: c.seq}
+	c.items[key] = e
+	heap.Push(&c.heap, e)
+	c.size += size
+	return evicted
+}
+
+func (c *lfuCache) remove(e *entry) {
+	heap.Remove(&c.heap, e.index)
+	delete(c.items, e.key)
+	c.size -= e.size
+}
+
+func (c *lfuCache) bytes() int {
+	return c.size
+}
+
+type entryHeap []*entry
+
+func (h entryHeap) Len() int { return len(h) }
+
+func (h entryHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entryHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,90 @@
+package cachesim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/hiwyw/dnscap-go/app/types/dnslogtest"
+)
+
+func TestCachePolicy(t *testing.T) {
+	expire := dnslogtest.Base.Add(time.Hour)
+
+	for policy, survivor := range map[string]string{
+		PolicyLRU:  "a",
+		PolicyFIFO: "b",
+		PolicyLFU:  "a",
+	} {
+		c, err := newCache(policy, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.set("a", expire, 10)
+		c.set("b", expire, 10)
+		c.get("a", dnslogtest.Base)
+		if evicted := c.set("c", expire, 10); !evicted {
+			t.Fatalf("%s should evict when full", policy)
+		}
+
+		for _, k := range []string{"a", "b"} {
+			hit, _ := c.get(k, dnslogtest.Base)
+			if hit != (k == survivor) {
+				t.Fatalf("%s key %s hit %v but survivor is %s", policy, k, hit, survivor)
+			}
+		}
+		if c.bytes() != 20 {
+			t.Fatalf("%s should hold 20 bytes but hold %d", policy, c.bytes())
+		}
+	}
+}
+
+func TestCacheExpire(t *testing.T) {
+	for _, policy := range []string{PolicyLRU, PolicyLFU, PolicyFIFO} {
+		c, _ := newCache(policy, 10)
+		c.set("a", dnslogtest.Base.Add(time.Second), 10)
+
+		if hit, expired := c.get("a", dnslogtest.Base); !hit || expired {
+			t.Fatalf("%s should hit before expire", policy)
+		}
+		if hit, expired := c.get("a", dnslogtest.Base.Add(time.Second)); hit || !expired {
+			t.Fatalf("%s should expire after ttl", policy)
+		}
+		if c.bytes() != 0 {
+			t.Fatalf("%s should be empty after expire but hold %d bytes", policy, c.bytes())
+		}
+	}
+}
+
+func TestMinTtl(t *testing.T) {
+	answer := dnslogtest.RRs(
+		"www.example.com. 300 IN CNAME cdn.example.net.",
+		"cdn.example.net. 60 IN A 192.0.2.1",
+	)
+	if ttl, ok := minTtl(answer, "", false); !ok || ttl != 60*time.Second {
+		t.Fatalf("min answer ttl should be 60s but got %s", ttl)
+	}
+
+	authority := dnslogtest.RRs(
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+	)
+	if ttl, ok := minTtl(authority, "SOA", true); !ok || ttl != 300*time.Second {
+		t.Fatalf("negative ttl should be 300s but got %s", ttl)
+	}
+}
+
+func TestCacheTtlSoaAnswer(t *testing.T) {
+	s := &CacheSim{}
+	soa := dnslogtest.RRs(
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+	)
+
+	// a soa query is a positive answer cached for the record ttl
+	if ttl := s.cacheTtl(&types.Dnslog{Rcode: "NOERROR", Answer: soa}); ttl != 3600*time.Second {
+		t.Fatalf("soa answer ttl should be 3600s but got %s", ttl)
+	}
+	if ttl := s.cacheTtl(&types.Dnslog{Rcode: "NXDOMAIN", Authority: soa}); ttl != 300*time.Second {
+		t.Fatalf("nxdomain ttl should be 300s but got %s", ttl)
+	}
+}
@@ -0,0 +1,241 @@
+package cachesim
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+const (
+	taskChannelBuffer = 100
+
+	entryOverhead = 128
+)
+
+func New(filename string, classifier *handler.Classifier, sizes []int, policies []string, maxTtl, maxNegativeTtl time.Duration) *CacheSim {
+	s := &CacheSim{
+		filename:       filename,
+		classifier:     classifier,
+		maxTtl:         maxTtl,
+		maxNegativeTtl: maxNegativeTtl,
+		taskCh:         make(chan *types.Dnslog, taskChannelBuffer),
+		closeCh:        make(chan struct{}),
+	}
+
+	for _, policy := range policies {
+		for _, size := range sizes {
+			c, err := newCache(policy, size)
+			if err != nil {
+				logger.Fatalf("create cache simulation failed %s", err)
+			}
+			s.sims = append(s.sims, &simulation{
+				cache:  c,
+				result: &SimResult{Policy: policy, Size: size},
+			})
+		}
+	}
+
+	go s.loop()
+	return s
+}
+
+type CacheSim struct {
+	filename       string
+	classifier     *handler.Classifier
+	maxTtl         time.Duration
+	maxNegativeTtl time.Duration
+	sims           []*simulation
+	report         Report
+	taskCh         chan *types.Dnslog
+	closeCh        chan struct{}
+}
+
+type simulation struct {
+	cache  cache
+	result *SimResult
+}
+
+type Report struct {
+	BeginTime time.Time    `json:"begin_time"`
+	EndTime   time.Time    `json:"end_time"`
+	Queries   int          `json:"queries"`
+	Cacheable int          `json:"cacheable_queries"`
+	Results   []*SimResult `json:"results"`
+}
+
+type SimResult struct {
+	Policy        string  `json:"policy"`
+	Size          int     `json:"size"`
+	Hits          int     `json:"hits"`
+	Misses        int     `json:"misses"`
+	Expired       int     `json:"expired"`
+	Evictions     int     `json:"evictions"`
+	HitRatio      float64 `json:"hit_ratio"`
+	UpstreamQps   float64 `json:"upstream_qps"`
+	PeakMemoryMB  float64 `json:"peak_memory_mb"`
+	peakMemoryRaw int
+}
+
+func (s *CacheSim) Handle(dl *types.Dnslog) {
+	s.taskCh <- dl
+}
+
+func (s *CacheSim) Stop() {
+	close(s.taskCh)
+	<-s.closeCh
+}
+
+func (s *CacheSim) loop() {
+	for {
+		dl, ok := <-s.taskCh
+		if !ok {
+			s.out()
+			s.closeCh <- struct{}{}
+			logger.Infof("cache simulation handler exiting")
+			return
+		}
+		s.simulate(dl)
+	}
+}
+
+func (s *CacheSim) simulate(dl *types.Dnslog) {
+	if !dl.Response || dl.Status != "" {
+		return
+	}
+
+	if s.classifier.HasSelfIps() {
+		if s.classifier.Side(dl) != handler.SideClient {
+			return
+		}
+	} else if s.classifier.IsRecursion(dl) {
+		return
+	}
+
+	queryTime := dl.PacketTime.Add(-dl.ResolvDuration)
+	if s.report.BeginTime.IsZero() || queryTime.Before(s.report.BeginTime) {
+		s.report.BeginTime = queryTime
+	}
+	if queryTime.After(s.report.EndTime) {
+		s.report.EndTime = queryTime
+	}
+
+	ttl := s.cacheTtl(dl)
+	s.report.Queries++
+	if ttl > 0 {
+		s.report.Cacheable++
+	}
+
+	key := strings.ToLower(dl.Domain) + "|" + dl.QueryType + "|" + dl.QueryClass
+	size := entryOverhead + len(key)
+	for _, rr := range dl.Answer {
+		size += len(rr.String())
+	}
+	for _, rr := range dl.Authority {
+		size += len(rr.String())
+	}
+
+	for _, sim := range s.sims {
+		hit, expired := sim.cache.get(key, queryTime)
+		if expired {
+			sim.result.Expired++
+		}
+		if hit {
+			sim.result.Hits++
+			continue
+		}
+
+		sim.result.Misses++
+		if ttl <= 0 {
+			continue
+		}
+		if sim.cache.set(key, queryTime.Add(ttl), size) {
+			sim.result.Evictions++
+		}
+		if b := sim.cache.bytes(); b > sim.result.peakMemoryRaw {
+			sim.result.peakMemoryRaw = b
+		}
+	}
+}
+
+func (s *CacheSim) cacheTtl(dl *types.Dnslog) time.Duration {
+	switch {
+	case dl.Rcode == "NOERROR" && len(dl.Answer) > 0:
+		ttl, ok := minTtl(dl.Answer, "", false)
+		if !ok {
+			return 0
+		}
+		return capTtl(ttl, s.maxTtl)
+	case dl.Rcode == "NXDOMAIN" || dl.Rcode == "NOERROR":
+		ttl, ok := minTtl(dl.Authority, "SOA", true)
+		if !ok {
+			return 0
+		}
+		return capTtl(ttl, s.maxNegativeTtl)
+	}
+	return 0
+}
+
+func capTtl(ttl, max time.Duration) time.Duration {
+	if max > 0 && ttl > max {
+		return max
+	}
+	return ttl
+}
+
+// minTtl returns the smallest ttl of the records of rrtype, or of all records
+// when rrtype is empty. With soaMinimum the soa minimum caps the ttl of soa
+// records as negative answers are cached for the smaller of them.
+func minTtl(rrs types.RRs, rrtype string, soaMinimum bool) (time.Duration, bool) {
+	var min uint32
+	found := false
+	for _, rr := range rrs {
+		if rrtype != "" && rr.Type != rrtype {
+			continue
+		}
+
+		ttl := rr.Ttl
+		if soaMinimum && rr.Soa != nil && rr.Soa.Minttl < ttl {
+			ttl = rr.Soa.Minttl
+		}
+
+		if !found || ttl < min {
+			min = ttl
+			found = true
+		}
+	}
+	return time.Duration(min) * time.Second, found
+}
+
+func (s *CacheSim) out() {
+	seconds := s.report.EndTime.Sub(s.report.BeginTime).Seconds()
+
+	for _, sim := range s.sims {
+		r := sim.result
+		if r.Hits+r.Misses > 0 {
+			r.HitRatio = float64(r.Hits) / float64(r.Hits+r.Misses)
+		}
+		if seconds > 0 {
+			r.UpstreamQps = float64(r.Misses) / seconds
+		}
+		r.PeakMemoryMB = float64(r.peakMemoryRaw) / 1024 / 1024
+		s.report.Results = append(s.report.Results, r)
+
+		logger.Infof("cache simulation policy %s size %d hit ratio %.4f upstream qps %.2f peak memory %.2fMB",
+			r.Policy, r.Size, r.HitRatio, r.UpstreamQps, r.PeakMemoryMB)
+	}
+
+	b, err := json.MarshalIndent(s.report, "", "    ")
+	if err != nil {
+		logger.Errorf("cache simulation result marshal to json failed %s", err)
+		return
+	}
+
+	if err := os.WriteFile(s.filename, b, 0644); err != nil {
+		logger.Errorf("write file %s failed %s", s.filename, err)
+	}
+}
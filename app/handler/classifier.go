@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+type Side int
+
+const (
+	SideOther Side = iota
+	SideClient
+	SideRecursion
+)
+
+func NewClassifier(selfIps []netip.Prefix, dnsPorts []uint16, heuristic bool) *Classifier {
+	c := &Classifier{
+		selfIps:   iptrie.New[struct{}](),
+		dnsPorts:  map[uint16]struct{}{},
+		heuristic: heuristic,
+	}
+
+	for _, p := range selfIps {
+		c.selfIps.Insert(p, struct{}{})
+	}
+
+	for _, p := range dnsPorts {
+		c.dnsPorts[p] = struct{}{}
+	}
+	return c
+}
+
+type Classifier struct {
+	selfIps   *iptrie.Trie[struct{}]
+	dnsPorts  map[uint16]struct{}
+	heuristic bool
+}
+
+func (c *Classifier) HasSelfIps() bool {
+	return c.selfIps.Len() > 0
+}
+
+func (c *Classifier) IsSelf(ip net.IP) bool {
+	return c.selfIps.ContainsIP(ip)
+}
+
+func (c *Classifier) IsRecursion(dl *types.Dnslog) bool {
+	return c.Side(dl) == SideRecursion
+}
+
+func (c *Classifier) Side(dl *types.Dnslog) Side {
+	if dl.Response {
+		switch {
+		case c.selfIps.ContainsIP(dl.DstIP) && c.isServerPort(dl, dl.SrcPort):
+			return SideRecursion
+		case c.selfIps.ContainsIP(dl.SrcIP) && c.isServerPort(dl, dl.SrcPort):
+			return SideClient
+		}
+		return SideOther
+	}
+	return c.TransactionSide(dl)
+}
+
+// TransactionSide classifies records oriented from client to server, which
+// covers queries and the combined records of transaction mode.
+func (c *Classifier) TransactionSide(dl *types.Dnslog) Side {
+	switch {
+	case c.selfIps.ContainsIP(dl.SrcIP) && c.isServerPort(dl, dl.DstPort):
+		return SideRecursion
+	case c.selfIps.ContainsIP(dl.DstIP) && c.isServerPort(dl, dl.DstPort):
+		return SideClient
+	}
+	return SideOther
+}
+
+func (c *Classifier) IsDnsPort(port uint16) bool {
+	_, ok := c.dnsPorts[port]
+	return ok
+}
+
+// isServerPort reports whether port is the server side of the record. Records
+// found by the heuristic payload check are on no configured port, their
+// direction comes from the message itself.
+func (c *Classifier) isServerPort(dl *types.Dnslog, port uint16) bool {
+	if c.heuristic && dl.Heuristic {
+		return true
+	}
+	return c.IsDnsPort(port)
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+func TestClassifierSide(t *testing.T) {
+	self := []netip.Prefix{netip.MustParsePrefix("10.0.0.53/32")}
+	record := func(src string, sport uint16, dst string, dport uint16, response, heuristic bool) *types.Dnslog {
+		return &types.Dnslog{
+			SrcIP:     net.ParseIP(src),
+			DstIP:     net.ParseIP(dst),
+			SrcPort:   sport,
+			DstPort:   dport,
+			Response:  response,
+			Heuristic: heuristic,
+		}
+	}
+
+	cases := []struct {
+		heuristic bool
+		dl        *types.Dnslog
+		side      Side
+	}{
+		{false, record("192.0.2.1", 40000, "10.0.0.53", 53, false, false), SideClient},
+		{false, record("10.0.0.53", 53, "192.0.2.1", 40000, true, false), SideClient},
+		{false, record("10.0.0.53", 40000, "198.51.100.1", 53, false, false), SideRecursion},
+		{false, record("198.51.100.1", 53, "10.0.0.53", 40000, true, false), SideRecursion},
+		// an arbitrary udp flow of the resolver is not dns in heuristic mode
+		{true, record("10.0.0.53", 40000, "198.51.100.1", 4500, false, false), SideOther},
+		{true, record("198.51.100.1", 4500, "10.0.0.53", 40000, true, false), SideOther},
+		// a record verified by the payload check takes the message direction
+		{true, record("10.0.0.53", 40000, "198.51.100.1", 5300, false, true), SideRecursion},
+		{true, record("10.0.0.53", 5300, "192.0.2.1", 40000, true, true), SideClient},
+		{false, record("10.0.0.53", 40000, "198.51.100.1", 5300, false, true), SideOther},
+	}
+
+	for i, c := range cases {
+		cl := NewClassifier(self, []uint16{53}, c.heuristic)
+		if side := cl.Side(c.dl); side != c.side {
+			t.Fatalf("case %d side got %d want %d", i, side, c.side)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package correlator
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/pkg/correlate"
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/natefinch/lumberjack"
+)
+
+const (
+	taskChannelBuffer = 100
+	batchWriteTimeout = time.Second * 1
+)
+
+func New(filename string, classifier *handler.Classifier, timeout time.Duration) *Correlator {
+	if !classifier.HasSelfIps() {
+		logger.Warnf("correlator has no self ips, no client query will be correlated")
+	}
+
+	c := &Correlator{
+		engine: correlate.NewEngine(classifier, timeout),
+		writer: &lumberjack.Logger{
+			Filename:   filename,
+			MaxSize:    50,
+			MaxBackups: 10,
+			MaxAge:     30,
+			Compress:   true,
+		},
+		taskCh:  make(chan *types.Dnslog, taskChannelBuffer),
+		closeCh: make(chan struct{}),
+	}
+	c.buffer = bufio.NewWriterSize(c.writer, 1024*8)
+
+	go c.loop()
+	return c
+}
+
+type Correlator struct {
+	engine  *correlate.Engine
+	writer  *lumberjack.Logger
+	buffer  *bufio.Writer
+	taskCh  chan *types.Dnslog
+	closeCh chan struct{}
+}
+
+func (c *Correlator) Handle(dl *types.Dnslog) {
+	c.taskCh <- dl
+}
+
+func (c *Correlator) Stop() {
+	close(c.taskCh)
+	<-c.closeCh
+	c.writer.Close()
+}
+
+func (c *Correlator) loop() {
+	for {
+		select {
+		case dl, ok := <-c.taskCh:
+			if !ok {
+				c.write(c.engine.Flush())
+				c.buffer.Flush()
+				c.closeCh <- struct{}{}
+				logger.Infof("correlator handler exiting")
+				return
+			}
+			c.write(c.engine.Add(dl))
+		case <-time.After(batchWriteTimeout):
+			c.buffer.Flush()
+		}
+	}
+}
+
+func (c *Correlator) write(txs []*correlate.Transaction) {
+	for _, tx := range txs {
+		if _, err := c.buffer.WriteString(format(tx) + "\n"); err != nil {
+			logger.Errorf("write file %s failed %s", c.writer.Filename, err)
+		}
+	}
+}
+
+// format renders a transaction as one line of the correlator file.
+func format(t *correlate.Transaction) string {
+	status := types.TransactionUnanswered
+	rcode := ""
+	if t.Response != nil {
+		status = types.TransactionAnswered
+		rcode = t.Response.Rcode
+	}
+
+	cache := "hit"
+	if !t.CacheHit {
+		cache = "miss"
+	}
+
+	ss := []string{
+		t.Query.PacketTime.Local().Format("2006-01-02 15:04:05.999999"),
+		t.Query.SrcIP.String(),
+		t.Query.DstIP.String(),
+		strconv.Itoa(int(t.Query.SrcPort)),
+		strconv.Itoa(int(t.Query.DstPort)),
+		strconv.Itoa(int(t.Query.TransID)),
+		status,
+		t.Query.Domain,
+		t.Query.QueryType,
+		rcode,
+		cache,
+		strconv.FormatInt(t.ClientLatency.Microseconds(), 10),
+		strconv.Itoa(t.UpstreamQueries),
+		strconv.FormatInt(t.UpstreamWait.Microseconds(), 10),
+		strconv.FormatFloat(t.UpstreamWaitRatio, 'f', 3, 64),
+		strings.Join(t.UpstreamNames, ";"),
+	}
+	return strings.Join(ss, "|")
+}
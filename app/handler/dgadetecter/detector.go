@@ -0,0 +1,115 @@
+package dgadetecter
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+const (
+	maxSamples = 5
+)
+
+type Client struct {
+	Client                  string    `json:"client"`
+	Responses               int       `json:"responses"`
+	Nxdomain                int       `json:"nxdomain"`
+	NxdomainRatio           float64   `json:"nxdomain_ratio"`
+	SuspiciousNames         int       `json:"suspicious_names"`
+	SuspiciousNxdomainNames int       `json:"suspicious_nxdomain_names"`
+	Samples                 []*Sample `json:"samples"`
+	names                   map[string]bool
+}
+
+type Sample struct {
+	Name  string  `json:"name"`
+	Label string  `json:"label"`
+	Score float64 `json:"score"`
+	Rcode string  `json:"rcode"`
+}
+
+// NewDetector scores the registered label of every qname answered to a
+// client and reports the clients asking for at least minNames distinct
+// suspicious names which were answered NXDOMAIN.
+func NewDetector(model *Model, minNames int) *Detector {
+	return &Detector{
+		model:    model,
+		minNames: minNames,
+		clients:  map[string]*Client{},
+	}
+}
+
+type Detector struct {
+	model    *Model
+	minNames int
+	clients  map[string]*Client
+}
+
+// Add counts a response sent to the client.
+func (d *Detector) Add(dl *types.Dnslog) {
+	client := dl.DstIP.String()
+	c, ok := d.clients[client]
+	if !ok {
+		c = &Client{Client: client, Samples: []*Sample{}, names: map[string]bool{}}
+		d.clients[client] = c
+	}
+
+	nxdomain := dl.Rcode == "NXDOMAIN"
+	c.Responses++
+	if nxdomain {
+		c.Nxdomain++
+	}
+
+	name := strings.ToLower(dl.Domain)
+	if seenNx, ok := c.names[name]; ok {
+		if nxdomain && !seenNx {
+			c.names[name] = true
+			c.SuspiciousNxdomainNames++
+		}
+		return
+	}
+
+	label := RegisteredLabel(name)
+	score, suspicious := d.model.Suspicious(label)
+	if !suspicious {
+		return
+	}
+
+	c.names[name] = nxdomain
+	c.SuspiciousNames++
+	if nxdomain {
+		c.SuspiciousNxdomainNames++
+	}
+	if len(c.Samples) < maxSamples {
+		c.Samples = append(c.Samples, &Sample{
+			Name:  dl.Domain,
+			Label: label,
+			Score: math.Round(score*1000) / 1000,
+			Rcode: dl.Rcode,
+		})
+	}
+}
+
+// Report returns the suspected clients sorted by suspicious NXDOMAIN names
+// and starts counting over.
+func (d *Detector) Report() []*Client {
+	clients := []*Client{}
+	for _, c := range d.clients {
+		if c.SuspiciousNxdomainNames < d.minNames {
+			continue
+		}
+		c.NxdomainRatio = math.Round(float64(c.Nxdomain)/float64(c.Responses)*10000) / 10000
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].SuspiciousNxdomainNames != clients[j].SuspiciousNxdomainNames {
+			return clients[i].SuspiciousNxdomainNames > clients[j].SuspiciousNxdomainNames
+		}
+		return clients[i].Client < clients[j].Client
+	})
+
+	d.clients = map[string]*Client{}
+	return clients
+}
@@ -0,0 +1,124 @@
+package dgadetecter
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/natefinch/lumberjack"
+)
+
+const (
+	taskChannelBuffer = 100
+)
+
+// New writes the suspected dga infected clients every interval of packet
+// time. The bundled model is used when modelList is empty.
+func New(filename string, interval time.Duration, modelList string, minNames int, classifier *handler.Classifier) *DgaDetecter {
+	model := BundledModel()
+	if modelList != "" {
+		var err error
+		if model, err = LoadModel(modelList); err != nil {
+			logger.Fatalf("load dga model failed %s", err)
+		}
+	}
+	logger.Infof("dga model threshold %.3f", model.Threshold)
+
+	d := &DgaDetecter{
+		detector:   NewDetector(model, minNames),
+		threshold:  model.Threshold,
+		classifier: classifier,
+		interval:   interval,
+		writer: &lumberjack.Logger{
+			Filename:   filename,
+			MaxSize:    50,
+			MaxBackups: 10,
+			MaxAge:     100,
+			Compress:   true,
+		},
+		taskCh:  make(chan *types.Dnslog, taskChannelBuffer),
+		closeCh: make(chan struct{}),
+	}
+
+	go d.loop()
+	return d
+}
+
+type DgaDetecter struct {
+	begin      bool
+	endTime    time.Time
+	detector   *Detector
+	threshold  float64
+	classifier *handler.Classifier
+	interval   time.Duration
+	writer     *lumberjack.Logger
+	taskCh     chan *types.Dnslog
+	closeCh    chan struct{}
+}
+
+type Report struct {
+	BeginTime time.Time `json:"begin_time"`
+	EndTime   time.Time `json:"end_time"`
+	Threshold float64   `json:"threshold"`
+	Clients   []*Client `json:"clients"`
+}
+
+func (d *DgaDetecter) Handle(dl *types.Dnslog) {
+	d.taskCh <- dl
+}
+
+func (d *DgaDetecter) Stop() {
+	close(d.taskCh)
+	<-d.closeCh
+	d.writer.Close()
+}
+
+func (d *DgaDetecter) loop() {
+	for {
+		dl, ok := <-d.taskCh
+		if !ok {
+			d.out()
+			d.closeCh <- struct{}{}
+			logger.Infof("dga detecter handler exiting")
+			return
+		}
+
+		if !d.begin {
+			d.endTime = dl.PacketTime.Add(d.interval)
+			d.begin = true
+		}
+		if dl.PacketTime.After(d.endTime) {
+			d.out()
+			d.endTime = d.endTime.Add(d.interval)
+		}
+
+		if dl.Response && !d.classifier.IsRecursion(dl) {
+			d.detector.Add(dl)
+		}
+	}
+}
+
+func (d *DgaDetecter) out() {
+	if !d.begin {
+		return
+	}
+
+	r := &Report{
+		BeginTime: d.endTime.Local().Add(-d.interval),
+		EndTime:   d.endTime.Local(),
+		Threshold: math.Round(d.threshold*1000) / 1000,
+		Clients:   d.detector.Report(),
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		logger.Errorf("dga report marshal to json failed %s", err)
+		return
+	}
+
+	if _, err := d.writer.Write(append(b, '\n')); err != nil {
+		logger.Errorf("write file %s failed %s", d.writer.Filename, err)
+	}
+}
@@ -0,0 +1,168 @@
+package dgadetecter
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/hiwyw/dnscap-go/app/pkg/publicsuffix"
+)
+
+const (
+	// symbols are the label boundary, a-z, digits as one class and hyphen
+	symbolBoundary = 0
+	symbolDigit    = 27
+	symbolHyphen   = 28
+	symbolCount    = 29
+
+	minLabelLen     = 6
+	smoothing       = 0.5
+	thresholdRank   = 0.01
+	defaultMinScore = -2.0
+)
+
+//go:embed benign_labels.txt
+var bundledLabels string
+
+// Model is a character bigram markov model of benign domain labels. Score is
+// the mean log10 probability of the transitions of a label, random looking
+// labels score below Threshold.
+type Model struct {
+	logProb   [symbolCount][symbolCount]float64
+	Threshold float64
+}
+
+func BundledModel() *Model {
+	return TrainModel(strings.Split(bundledLabels, "\n"))
+}
+
+// LoadModel trains a model on a benign domain list with one domain per line,
+// the registered label of every domain is used.
+func LoadModel(path string) (*Model, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open dga model list %s failed %s", path, err)
+	}
+	defer f.Close()
+
+	labels := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		labels = append(labels, RegisteredLabel(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read dga model list %s failed %s", path, err)
+	}
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("dga model list %s empty", path)
+	}
+	return TrainModel(labels), nil
+}
+
+func TrainModel(labels []string) *Model {
+	counts := [symbolCount][symbolCount]float64{}
+	trained := []string{}
+	for _, l := range labels {
+		l = strings.ToLower(strings.TrimSpace(l))
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		trained = append(trained, l)
+		forEachTransition(l, func(from, to int) {
+			counts[from][to]++
+		})
+	}
+
+	m := &Model{}
+	for from := range counts {
+		total := 0.0
+		for _, c := range counts[from] {
+			total += c + smoothing
+		}
+		for to, c := range counts[from] {
+			m.logProb[from][to] = math.Log10((c + smoothing) / total)
+		}
+	}
+
+	// the threshold keeps all but the lowest scoring training labels benign
+	scores := []float64{}
+	for _, l := range trained {
+		if len(l) >= minLabelLen {
+			scores = append(scores, m.Score(l))
+		}
+	}
+	m.Threshold = defaultMinScore
+	if len(scores) > 0 {
+		sort.Float64s(scores)
+		m.Threshold = scores[int(float64(len(scores))*thresholdRank)]
+	}
+	return m
+}
+
+func (m *Model) Score(label string) float64 {
+	sum, n := 0.0, 0
+	forEachTransition(strings.ToLower(label), func(from, to int) {
+		sum += m.logProb[from][to]
+		n++
+	})
+	return sum / float64(n)
+}
+
+// Suspicious reports labels long enough to judge and scoring below the
+// threshold, punycode labels are not judged.
+func (m *Model) Suspicious(label string) (float64, bool) {
+	if len(label) < minLabelLen || strings.HasPrefix(label, "xn--") {
+		return 0, false
+	}
+	score := m.Score(label)
+	return score, score < m.Threshold
+}
+
+func forEachTransition(label string, fn func(from, to int)) {
+	from := symbolBoundary
+	for i := 0; i < len(label); i++ {
+		to := symbol(label[i])
+		if to < 0 {
+			continue
+		}
+		fn(from, to)
+		from = to
+	}
+	fn(from, symbolBoundary)
+}
+
+func symbol(c byte) int {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return int(c-'a') + 1
+	case c >= '0' && c <= '9':
+		return symbolDigit
+	case c == '-':
+		return symbolHyphen
+	}
+	return -1
+}
+
+// RegisteredLabel returns the label left of the public suffix of the name.
+func RegisteredLabel(name string) string {
+	name = strings.ToLower(name)
+	if registered := publicsuffix.RegisteredDomain(name); registered != "" {
+		name = registered
+	}
+
+	labels := dns.SplitDomainName(name)
+	if len(labels) == 0 {
+		return ""
+	}
+	return labels[0]
+}
@@ -0,0 +1,108 @@
+package dgadetecter
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+func TestRegisteredLabel(t *testing.T) {
+	cases := map[string]string{
+		"www.example.com.":    "example",
+		"a.b.example.com.cn.": "example",
+		"example.com.cn":      "example",
+		"com.cn.":             "com",
+		"www.example.co.za.":  "example",
+		"localhost.":          "localhost",
+		".":                   "",
+	}
+	for name, label := range cases {
+		if l := RegisteredLabel(name); l != label {
+			t.Fatalf("registered label of %s should be %s but %s", name, label, l)
+		}
+	}
+}
+
+func randomLabel(r *rand.Rand) string {
+	b := make([]byte, 12+r.Intn(8))
+	for i := range b {
+		b[i] = byte('a' + r.Intn(26))
+	}
+	return string(b)
+}
+
+func TestBundledModel(t *testing.T) {
+	m := BundledModel()
+	// labels missing from the training list
+	for _, l := range []string{"facebook", "wikipedia", "stackoverflow", "cloudflare", "jingdong", "shoponline"} {
+		if score, ok := m.Suspicious(l); ok {
+			t.Fatalf("%s should be benign but score %f threshold %f", l, score, m.Threshold)
+		}
+	}
+	for _, l := range []string{"qzxcvbnmlk", "xjwqkzptfd", "kdjfhgqwezx"} {
+		if score, ok := m.Suspicious(l); !ok {
+			t.Fatalf("%s should be suspicious but score %f threshold %f", l, score, m.Threshold)
+		}
+	}
+	if _, ok := m.Suspicious("xn--fiqs8s"); ok {
+		t.Fatalf("punycode label should not be judged")
+	}
+
+	r := rand.New(rand.NewSource(1))
+	flagged := 0
+	for i := 0; i < 1000; i++ {
+		if _, ok := m.Suspicious(randomLabel(r)); ok {
+			flagged++
+		}
+	}
+	if flagged < 950 {
+		t.Fatalf("only %d of 1000 random labels flagged", flagged)
+	}
+}
+
+func TestLoadModel(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "benign.txt")
+	content := "# internal domains\nwww.intranet-portal.example.com\nmail.intranet-portal.example.com\nhrsystem.com.cn\n"
+	if err := os.WriteFile(fp, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadModel(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Score("hrsystem") <= m.Score("qzxcvbnm") {
+		t.Fatalf("trained label should score above random label")
+	}
+}
+
+func TestDetector(t *testing.T) {
+	d := NewDetector(BundledModel(), 3)
+	r := rand.New(rand.NewSource(1))
+
+	response := func(client, name, rcode string) *types.Dnslog {
+		return &types.Dnslog{DstIP: net.ParseIP(client), Domain: name, Rcode: rcode, Response: true}
+	}
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("%s.com.", randomLabel(r))
+		d.Add(response("10.0.0.1", name, "NXDOMAIN"))
+		d.Add(response("10.0.0.1", name, "NXDOMAIN"))
+		d.Add(response("10.0.0.1", "www.google.com.", "NOERROR"))
+	}
+	d.Add(response("10.0.0.2", randomLabel(r)+".net.", "NXDOMAIN"))
+	d.Add(response("10.0.0.2", "www.facebook.com.", "NOERROR"))
+
+	clients := d.Report()
+	if len(clients) != 1 || clients[0].Client != "10.0.0.1" {
+		t.Fatalf("unexpected clients %+v", clients)
+	}
+	c := clients[0]
+	if c.Responses != 30 || c.Nxdomain != 20 || c.NxdomainRatio != 0.6667 || c.SuspiciousNxdomainNames < 9 || len(c.Samples) != maxSamples {
+		t.Fatalf("unexpected client %+v", c)
+	}
+}
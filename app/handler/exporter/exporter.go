@@ -0,0 +1,225 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+const (
+	taskChannelBuffer = 100
+)
+
+type Option struct {
+	Filename       string
+	TimingFilename string
+	RateFilename   string
+	Dedup          bool
+	Clients        []netip.Prefix
+	Weight         int
+}
+
+// New writes the client queries in "qname qtype" lines which both dnsperf and
+// resperf accept. The timing file prefixes each line with the offset in
+// seconds from the first query, and the rate file records the per second
+// query count of the capture before dedup.
+func New(opt Option, classifier *handler.Classifier) (*Exporter, error) {
+	e, err := newExporter(opt, classifier)
+	if err != nil {
+		return nil, err
+	}
+
+	go e.loop()
+	return e, nil
+}
+
+func newExporter(opt Option, classifier *handler.Classifier) (*Exporter, error) {
+	if opt.Weight <= 0 {
+		opt.Weight = 1
+	}
+
+	e := &Exporter{
+		opt:        opt,
+		classifier: classifier,
+		clients:    iptrie.New[struct{}](),
+		seen:       map[string]struct{}{},
+		rates:      map[int64]int{},
+		taskCh:     make(chan *types.Dnslog, taskChannelBuffer),
+		closeCh:    make(chan struct{}),
+	}
+
+	for _, p := range opt.Clients {
+		e.clients.Insert(p, struct{}{})
+	}
+
+	var err error
+	if e.out, err = create(opt.Filename); err != nil {
+		return nil, err
+	}
+	if opt.TimingFilename != "" {
+		if e.timing, err = create(opt.TimingFilename); err != nil {
+			e.out.Close()
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+type Exporter struct {
+	opt        Option
+	classifier *handler.Classifier
+	clients    *iptrie.Trie[struct{}]
+	seen       map[string]struct{}
+	rates      map[int64]int
+	begin      time.Time
+	queries    int
+	exported   int
+	out        *file
+	timing     *file
+	taskCh     chan *types.Dnslog
+	closeCh    chan struct{}
+}
+
+type file struct {
+	*bufio.Writer
+	f *os.File
+}
+
+func create(filename string) (*file, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("create file %s failed %s", filename, err)
+	}
+	return &file{Writer: bufio.NewWriterSize(f, 1024*64), f: f}, nil
+}
+
+func (f *file) Close() error {
+	if err := f.Flush(); err != nil {
+		f.f.Close()
+		return err
+	}
+	return f.f.Close()
+}
+
+func (e *Exporter) Handle(dl *types.Dnslog) {
+	e.taskCh <- dl
+}
+
+func (e *Exporter) Stop() {
+	close(e.taskCh)
+	<-e.closeCh
+}
+
+func (e *Exporter) loop() {
+	for {
+		dl, ok := <-e.taskCh
+		if !ok {
+			if err := e.close(); err != nil {
+				logger.Errorf("export queries failed %s", err)
+			}
+			logger.Infof("exported %d of %d client queries to %s", e.exported, e.queries, e.opt.Filename)
+			e.closeCh <- struct{}{}
+			logger.Infof("exporter handler exiting")
+			return
+		}
+		e.export(dl)
+	}
+}
+
+func (e *Exporter) export(dl *types.Dnslog) {
+	if dl.Response || dl.Retries > 0 || dl.Domain == "" || dl.QueryType == "" {
+		return
+	}
+
+	if e.classifier.HasSelfIps() {
+		if e.classifier.Side(dl) != handler.SideClient {
+			return
+		}
+	} else if e.classifier.IsRecursion(dl) {
+		return
+	}
+
+	if e.clients.Len() > 0 && !e.clients.ContainsIP(dl.SrcIP) {
+		return
+	}
+
+	if e.begin.IsZero() {
+		e.begin = dl.PacketTime
+	}
+	e.queries++
+	e.rates[dl.PacketTime.Unix()] += e.opt.Weight
+
+	line := dl.Domain + " " + dl.QueryType
+	if e.opt.Dedup {
+		k := strings.ToLower(line)
+		if _, ok := e.seen[k]; ok {
+			return
+		}
+		e.seen[k] = struct{}{}
+	}
+	e.exported++
+
+	if _, err := e.out.WriteString(line + "\n"); err != nil {
+		logger.Errorf("write file %s failed %s", e.opt.Filename, err)
+	}
+
+	if e.timing != nil {
+		offset := dl.PacketTime.Sub(e.begin).Seconds()
+		if _, err := e.timing.WriteString(fmt.Sprintf("%.6f %s\n", offset, line)); err != nil {
+			logger.Errorf("write file %s failed %s", e.opt.TimingFilename, err)
+		}
+	}
+}
+
+func (e *Exporter) close() error {
+	if err := e.out.Close(); err != nil {
+		return fmt.Errorf("write file %s failed %s", e.opt.Filename, err)
+	}
+
+	if e.timing != nil {
+		if err := e.timing.Close(); err != nil {
+			return fmt.Errorf("write file %s failed %s", e.opt.TimingFilename, err)
+		}
+	}
+
+	if e.opt.RateFilename == "" {
+		return nil
+	}
+	return e.writeRates()
+}
+
+func (e *Exporter) writeRates() error {
+	f, err := create(e.opt.RateFilename)
+	if err != nil {
+		return err
+	}
+
+	if len(e.rates) > 0 {
+		first, last := e.begin.Unix(), e.begin.Unix()
+		for sec := range e.rates {
+			if sec < first {
+				first = sec
+			}
+			if sec > last {
+				last = sec
+			}
+		}
+
+		for sec := first; sec <= last; sec++ {
+			fmt.Fprintf(f, "%d %d\n", sec-first, e.rates[sec])
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write file %s failed %s", e.opt.RateFilename, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package exporter
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/hiwyw/dnscap-go/app/types/dnslogtest"
+)
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	opt := Option{
+		Filename:       filepath.Join(dir, "queries.txt"),
+		TimingFilename: filepath.Join(dir, "timing.txt"),
+		RateFilename:   filepath.Join(dir, "rate.txt"),
+		Dedup:          true,
+		Clients:        []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+	}
+	c := handler.NewClassifier([]netip.Prefix{netip.MustParsePrefix("10.0.0.53/32")}, []uint16{53}, false)
+
+	e, err := newExporter(opt, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retry := dnslogtest.Packet(200, "10.0.0.1", "10.0.0.53", 40000, 53, 0, "mail.example.com.", false)
+	retry.Retries = 1
+	for _, dl := range []*types.Dnslog{
+		dnslogtest.Packet(0, "10.0.0.1", "10.0.0.53", 40000, 53, 0, "www.example.com.", false),
+		dnslogtest.Packet(100, "10.0.0.2", "10.0.0.53", 40000, 53, 0, "WWW.example.com.", false),
+		retry,
+		dnslogtest.Packet(300, "10.0.1.1", "10.0.0.53", 40000, 53, 0, "other.example.com.", false),
+		dnslogtest.Packet(2500, "10.0.0.1", "10.0.0.53", 40000, 53, 0, "api.example.com.", false),
+	} {
+		e.export(dl)
+	}
+	if err := e.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for filename, want := range map[string]string{
+		opt.Filename:       "www.example.com. A\napi.example.com. A\n",
+		opt.TimingFilename: "0.000000 www.example.com. A\n2.500000 api.example.com. A\n",
+		opt.RateFilename:   "0 2\n1 0\n2 1\n",
+	} {
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Fatalf("%s should be %q but got %q", filepath.Base(filename), want, string(b))
+		}
+	}
+}
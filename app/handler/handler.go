@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"github.com/hiwyw/dnscap-go/app/filter"
 	"github.com/hiwyw/dnscap-go/app/types"
 )
 
@@ -8,3 +9,28 @@ type Handler interface {
 	Handle(dl *types.Dnslog)
 	Stop()
 }
+
+// FlowHandler receives every captured udp and tcp packet, whether or not it
+// carries dns.
+type FlowHandler interface {
+	HandleFlow(f *types.Flow)
+	Stop()
+}
+
+func Filtered(h Handler, f *filter.Filter) Handler {
+	if f == nil {
+		return h
+	}
+	return &filteredHandler{Handler: h, f: f}
+}
+
+type filteredHandler struct {
+	Handler
+	f *filter.Filter
+}
+
+func (h *filteredHandler) Handle(dl *types.Dnslog) {
+	if h.f.Match(dl) {
+		h.Handler.Handle(dl)
+	}
+}
@@ -0,0 +1,258 @@
+package intel
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/hiwyw/dnscap-go/app/pkg/domaintrie"
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+)
+
+const (
+	FormatDomain = "domain"
+	FormatIp     = "ip"
+	FormatRpz    = "rpz"
+
+	rpzIpLabel = "rpz-ip"
+)
+
+type List struct {
+	Name   string
+	Format string
+	Path   string
+}
+
+// indicators holds the entries of all lists. A domain entry of a plain list or
+// hosts file matches the name and all names under it, a wildcard entry only
+// matches the names under it.
+type indicators struct {
+	domains *domaintrie.Trie[string]
+	ips     *iptrie.Trie[string]
+	count   int
+}
+
+func newIndicators() *indicators {
+	return &indicators{
+		domains: domaintrie.New[string](),
+		ips:     iptrie.New[string](),
+	}
+}
+
+func loadIndicators(lists []List) (*indicators, error) {
+	ind := newIndicators()
+	for _, l := range lists {
+		var err error
+		switch l.Format {
+		case FormatDomain, "":
+			err = ind.loadDomains(l)
+		case FormatIp:
+			err = ind.loadIps(l)
+		case FormatRpz:
+			err = ind.loadRpz(l)
+		default:
+			err = fmt.Errorf("unknown list format %s", l.Format)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("load list %s failed %s", l.Name, err)
+		}
+	}
+	return ind, nil
+}
+
+func (ind *indicators) addDomain(name, list string, subdomains bool) error {
+	if subdomains && !strings.HasPrefix(name, "*.") {
+		name = "." + name
+	}
+	if err := ind.domains.Insert(name, list); err != nil {
+		return err
+	}
+	ind.count++
+	return nil
+}
+
+func (ind *indicators) addPrefix(p netip.Prefix, list string) {
+	ind.count++
+	ind.ips.Insert(p, list)
+}
+
+// matchDomain returns the lists containing the name, or a wildcard entry of
+// one of its parents.
+func (ind *indicators) matchDomain(name string) []string {
+	var lists []string
+	for _, l := range ind.domains.Match(name) {
+		lists = appendUnique(lists, l)
+	}
+	return lists
+}
+
+func (ind *indicators) matchIp(addr netip.Addr) []string {
+	lists := []string{}
+	for _, l := range ind.ips.Match(addr) {
+		lists = appendUnique(lists, l)
+	}
+	return lists
+}
+
+func (ind *indicators) loadDomains(l List) error {
+	return readLines(l.Path, func(fs []string) error {
+		// hosts file lines start with the address the names resolve to
+		if _, err := netip.ParseAddr(fs[0]); err == nil {
+			fs = fs[1:]
+		}
+		for _, name := range fs {
+			if _, ok := dns.IsDomainName(name); !ok {
+				return fmt.Errorf("%s not domain name", name)
+			}
+			if normalize(name) == "localhost." {
+				continue
+			}
+			if err := ind.addDomain(name, l.Name, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ind *indicators) loadIps(l List) error {
+	return readLines(l.Path, func(fs []string) error {
+		prefixes, err := iptrie.Parse(fs[0])
+		if err != nil {
+			return err
+		}
+		for _, p := range prefixes {
+			ind.addPrefix(p, l.Name)
+		}
+		return nil
+	})
+}
+
+// loadRpz loads the qname and response ip triggers of a response policy zone,
+// rpz-passthru rules are skipped as they exempt names rather than list them.
+func (ind *indicators) loadRpz(l List) error {
+	f, err := os.Open(l.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	origin := ""
+	zp := dns.NewZoneParser(f, ".", l.Path)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeSOA {
+			origin = strings.ToLower(h.Name)
+			continue
+		}
+		if h.Rrtype == dns.TypeNS {
+			continue
+		}
+		if cname, ok := rr.(*dns.CNAME); ok && strings.HasPrefix(strings.ToLower(cname.Target), "rpz-passthru.") {
+			continue
+		}
+
+		owner := strings.ToLower(h.Name)
+		if origin != "" {
+			if owner == origin || !strings.HasSuffix(owner, "."+origin) {
+				continue
+			}
+			owner = strings.TrimSuffix(owner, origin)
+		}
+
+		labels := dns.SplitDomainName(owner)
+		if len(labels) > 0 && labels[len(labels)-1] == rpzIpLabel {
+			p, err := rpzPrefix(labels[:len(labels)-1])
+			if err != nil {
+				return fmt.Errorf("parse rpz trigger %s failed %s", h.Name, err)
+			}
+			ind.addPrefix(p, l.Name)
+			continue
+		}
+		if len(labels) > 0 && strings.HasPrefix(labels[len(labels)-1], "rpz-") {
+			continue
+		}
+		if err := ind.addDomain(owner, l.Name, false); err != nil {
+			return fmt.Errorf("parse rpz trigger %s failed %s", h.Name, err)
+		}
+	}
+	return zp.Err()
+}
+
+// rpzPrefix parses the reversed labels of an rpz-ip trigger, such as
+// 24.0.2.0.192 for 192.0.2.0/24 or 128.zz.1.db8.2001 for 2001:db8:1::/128.
+func rpzPrefix(labels []string) (netip.Prefix, error) {
+	if len(labels) < 2 {
+		return netip.Prefix{}, fmt.Errorf("too few labels")
+	}
+
+	bits, parts := labels[0], labels[1:]
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+
+	if len(parts) == 4 && !strings.Contains(strings.Join(parts, "."), "zz") {
+		return netip.ParsePrefix(strings.Join(parts, ".") + "/" + bits)
+	}
+
+	// zz stands for the longest run of zero fields, written as :: in ipv6
+	for i, p := range parts {
+		if p != "zz" {
+			continue
+		}
+		parts[i] = ""
+		if i == 0 {
+			parts = append([]string{""}, parts...)
+		} else if i == len(parts)-1 {
+			parts = append(parts, "")
+		}
+		break
+	}
+	return netip.ParsePrefix(strings.Join(parts, ":") + "/" + bits)
+}
+
+func readLines(path string, fn func(fs []string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fs := strings.Fields(line)
+		if len(fs) == 0 {
+			continue
+		}
+		if err := fn(fs); err != nil {
+			return fmt.Errorf("line %d %s", n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func normalize(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+func appendUnique(ss []string, s string) []string {
+	for _, v := range ss {
+		if v == s {
+			return ss
+		}
+	}
+	ss = append(append([]string{}, ss...), s)
+	sort.Strings(ss)
+	return ss
+}
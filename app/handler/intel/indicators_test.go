@@ -0,0 +1,73 @@
+package intel
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestIndicators(t *testing.T) {
+	dir := t.TempDir()
+	lists := []List{
+		{Name: "plain", Format: FormatDomain, Path: writeFile(t, dir, "plain.txt", "# comment\nbad.example.com\n*.wild.example.com\n")},
+		{Name: "hosts", Format: FormatDomain, Path: writeFile(t, dir, "hosts", "127.0.0.1 localhost\n0.0.0.0 ads.example.net tracker.example.net # ads\n")},
+		{Name: "ips", Format: FormatIp, Path: writeFile(t, dir, "ips.txt", "192.0.2.0/24\n198.51.100.1-198.51.100.3\n")},
+		{Name: "rpz", Format: FormatRpz, Path: writeFile(t, dir, "rpz.zone", `$TTL 300
+$ORIGIN rpz.local.
+@ SOA ns.rpz.local. admin.rpz.local. 1 3600 600 86400 300
+  NS ns.rpz.local.
+evil.example.org CNAME .
+*.evil.example.org CNAME .
+good.evil.example.org CNAME rpz-passthru.
+32.1.2.0.192.rpz-ip CNAME .
+48.zz.db8.2001.rpz-ip CNAME .
+`)},
+	}
+
+	ind, err := loadIndicators(lists)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string][]string{
+		"bad.example.com.":        {"plain"},
+		"www.BAD.example.com":     {"plain"},
+		"wild.example.com.":       nil,
+		"a.wild.example.com.":     {"plain"},
+		"ads.example.net.":        {"hosts"},
+		"localhost.":              nil,
+		"evil.example.org.":       {"rpz"},
+		"x.evil.example.org.":     {"rpz"},
+		"good.evil.example.org.":  {"rpz"},
+		"example.org.":            nil,
+		"notbad.example.com.":     nil,
+		"bad.example.com.evil.cn": nil,
+	} {
+		if got := ind.matchDomain(name); len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Fatalf("%s should match %v but match %v", name, want, got)
+		}
+	}
+
+	for addr, want := range map[string][]string{
+		"192.0.2.1":    {"ips", "rpz"},
+		"192.0.2.2":    {"ips"},
+		"198.51.100.3": {"ips"},
+		"198.51.100.4": {},
+		"2001:db8::1":  {"rpz"},
+		"2001:db9::1":  {},
+	} {
+		if got := ind.matchIp(netip.MustParseAddr(addr)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s should match %v but match %v", addr, want, got)
+		}
+	}
+}
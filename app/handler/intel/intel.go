@@ -0,0 +1,260 @@
+package intel
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/natefinch/lumberjack"
+)
+
+const (
+	taskChannelBuffer = 100
+	batchWriteTimeout = time.Second * 1
+
+	MatchQname      = "qname"
+	MatchAnswerName = "answer_name"
+	MatchAnswerIp   = "answer_ip"
+)
+
+// New matches queries on the qname and responses on the answer addresses and
+// cname targets against the lists. The lists are reloaded in the background
+// when any of the files changes.
+func New(lists []List, filename, metricFilename string, reloadInterval, metricInterval time.Duration, weight int) *Intel {
+	ind, err := loadIndicators(lists)
+	if err != nil {
+		logger.Fatalf("load intel lists failed %s", err)
+	}
+	logger.Infof("intel lists loaded with %d indicators", ind.count)
+
+	i := &Intel{
+		lists:          lists,
+		indicators:     ind,
+		signature:      signature(lists),
+		reloadInterval: reloadInterval,
+		metricInterval: metricInterval,
+		weight:         weight,
+		metric:         newMetric(),
+		writer:         newWriter(filename),
+		metricWriter:   newWriter(metricFilename),
+		reloadCh:       make(chan *reload, 1),
+		taskCh:         make(chan *types.Dnslog, taskChannelBuffer),
+		closeCh:        make(chan struct{}),
+	}
+	i.buffer = bufio.NewWriterSize(i.writer, 1024*8)
+
+	go i.loop()
+	return i
+}
+
+func newWriter(filename string) *lumberjack.Logger {
+	return &lumberjack.Logger{
+		Filename:   filename,
+		MaxSize:    50,
+		MaxBackups: 10,
+		MaxAge:     30,
+		Compress:   true,
+	}
+}
+
+type Intel struct {
+	lists          []List
+	indicators     *indicators
+	signature      string
+	reloading      bool
+	reloadInterval time.Duration
+	metricInterval time.Duration
+	weight         int
+	metric         *Metric
+	writer         *lumberjack.Logger
+	buffer         *bufio.Writer
+	metricWriter   *lumberjack.Logger
+	reloadCh       chan *reload
+	taskCh         chan *types.Dnslog
+	closeCh        chan struct{}
+}
+
+type reload struct {
+	indicators *indicators
+	signature  string
+	err        error
+}
+
+type Metric struct {
+	BeginTime time.Time      `json:"begin_time"`
+	EndTime   time.Time      `json:"end_time"`
+	Checked   int            `json:"checked"`
+	Hits      int            `json:"hits"`
+	ListHits  map[string]int `json:"list_hits"`
+}
+
+func newMetric() *Metric {
+	return &Metric{
+		BeginTime: time.Now(),
+		ListHits:  map[string]int{},
+	}
+}
+
+type hit struct {
+	match     string
+	indicator string
+	lists     []string
+}
+
+func (i *Intel) Handle(dl *types.Dnslog) {
+	i.taskCh <- dl
+}
+
+func (i *Intel) Stop() {
+	close(i.taskCh)
+	<-i.closeCh
+	i.writer.Close()
+	i.metricWriter.Close()
+}
+
+func (i *Intel) loop() {
+	reloadTicker := time.NewTicker(i.reloadInterval)
+	defer reloadTicker.Stop()
+	metricTicker := time.NewTicker(i.metricInterval)
+	defer metricTicker.Stop()
+
+	for {
+		select {
+		case dl, ok := <-i.taskCh:
+			if !ok {
+				i.buffer.Flush()
+				i.outMetric()
+				i.closeCh <- struct{}{}
+				logger.Infof("intel handler exiting")
+				return
+			}
+			i.check(dl)
+		case <-time.After(batchWriteTimeout):
+			i.buffer.Flush()
+		case <-reloadTicker.C:
+			i.checkReload()
+		case r := <-i.reloadCh:
+			i.reloading = false
+			if r.err != nil {
+				logger.Errorf("reload intel lists failed %s, keep using the old lists", r.err)
+				continue
+			}
+			i.indicators, i.signature = r.indicators, r.signature
+			logger.Infof("intel lists reloaded with %d indicators", r.indicators.count)
+		case <-metricTicker.C:
+			i.outMetric()
+		}
+	}
+}
+
+func (i *Intel) checkReload() {
+	if i.reloading {
+		return
+	}
+
+	sig := signature(i.lists)
+	if sig == i.signature {
+		return
+	}
+
+	i.reloading = true
+	go func() {
+		ind, err := loadIndicators(i.lists)
+		i.reloadCh <- &reload{indicators: ind, signature: sig, err: err}
+	}()
+}
+
+// signature changes when any list file is modified, replaced or removed.
+func signature(lists []List) string {
+	ss := []string{}
+	for _, l := range lists {
+		info, err := os.Stat(l.Path)
+		if err != nil {
+			ss = append(ss, l.Path+" missing")
+			continue
+		}
+		ss = append(ss, fmt.Sprintf("%s %d %d", l.Path, info.Size(), info.ModTime().UnixNano()))
+	}
+	return strings.Join(ss, ";")
+}
+
+func (i *Intel) check(dl *types.Dnslog) {
+	i.metric.Checked += i.weight
+	for _, h := range i.match(dl) {
+		i.metric.Hits += i.weight
+		for _, l := range h.lists {
+			i.metric.ListHits[l] += i.weight
+		}
+		i.write(dl, h)
+	}
+}
+
+func (i *Intel) match(dl *types.Dnslog) []*hit {
+	hits := []*hit{}
+	if !dl.Response {
+		if lists := i.indicators.matchDomain(dl.Domain); len(lists) > 0 {
+			hits = append(hits, &hit{match: MatchQname, indicator: dl.Domain, lists: lists})
+		}
+		return hits
+	}
+
+	for _, rr := range dl.Answer {
+		switch {
+		case rr.Address != nil:
+			if lists := i.indicators.matchIp(iptrie.FromIP(rr.Address)); len(lists) > 0 {
+				hits = append(hits, &hit{match: MatchAnswerIp, indicator: rr.Address.String(), lists: lists})
+			}
+		case rr.Type == "CNAME" || rr.Type == "DNAME":
+			if lists := i.indicators.matchDomain(rr.Target); len(lists) > 0 {
+				hits = append(hits, &hit{match: MatchAnswerName, indicator: rr.Target, lists: lists})
+			}
+		}
+	}
+	return hits
+}
+
+func (i *Intel) write(dl *types.Dnslog, h *hit) {
+	packetType := "query"
+	if dl.Response {
+		packetType = "response"
+	}
+
+	ss := []string{
+		dl.PacketTime.Local().Format("2006-01-02 15:04:05.999999"),
+		dl.SrcIP.String(),
+		dl.DstIP.String(),
+		strconv.Itoa(int(dl.SrcPort)),
+		strconv.Itoa(int(dl.DstPort)),
+		strconv.Itoa(int(dl.TransID)),
+		packetType,
+		dl.Domain,
+		dl.QueryType,
+		h.match,
+		h.indicator,
+		strings.Join(h.lists, ";"),
+	}
+	if _, err := i.buffer.WriteString(strings.Join(ss, "|") + "\n"); err != nil {
+		logger.Errorf("write file %s failed %s", i.writer.Filename, err)
+	}
+}
+
+func (i *Intel) outMetric() {
+	i.metric.EndTime = time.Now()
+	b, err := json.Marshal(i.metric)
+	if err != nil {
+		logger.Errorf("intel metric marshal to json failed %s", err)
+		return
+	}
+
+	if _, err := i.metricWriter.Write(append(b, '\n')); err != nil {
+		logger.Errorf("write file %s failed %s", i.metricWriter.Filename, err)
+	}
+	i.metric = newMetric()
+}
@@ -0,0 +1,123 @@
+package passivedns
+
+import (
+	"strings"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/pkg/pdns"
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+const (
+	taskChannelBuffer = 100
+)
+
+// New aggregates the answer records of successful responses in memory and
+// flushes them to the passive dns store every interval.
+func New(dir string, interval time.Duration, weight int) *PassiveDns {
+	store, err := pdns.Open(dir)
+	if err != nil {
+		logger.Fatalf("open passive dns store failed %s", err)
+	}
+
+	p := &PassiveDns{
+		store:    store,
+		interval: interval,
+		weight:   weight,
+		entries:  map[string]*pdns.Entry{},
+		taskCh:   make(chan *types.Dnslog, taskChannelBuffer),
+		closeCh:  make(chan struct{}),
+	}
+
+	go p.loop()
+	return p
+}
+
+type PassiveDns struct {
+	store    *pdns.Store
+	interval time.Duration
+	weight   int
+	entries  map[string]*pdns.Entry
+	taskCh   chan *types.Dnslog
+	closeCh  chan struct{}
+}
+
+func (p *PassiveDns) Handle(dl *types.Dnslog) {
+	p.taskCh <- dl
+}
+
+func (p *PassiveDns) Stop() {
+	close(p.taskCh)
+	<-p.closeCh
+	p.store.Close()
+}
+
+func (p *PassiveDns) loop() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case dl, ok := <-p.taskCh:
+			if !ok {
+				p.flush()
+				p.closeCh <- struct{}{}
+				logger.Infof("passive dns handler exiting")
+				return
+			}
+			p.observe(dl)
+		case <-ticker.C:
+			p.flush()
+		}
+	}
+}
+
+func (p *PassiveDns) observe(dl *types.Dnslog) {
+	if !dl.Response || dl.Status != "" || dl.Rcode != "NOERROR" {
+		return
+	}
+
+	for _, rr := range dl.Answer {
+		e := &pdns.Entry{
+			RRName:    pdns.NormalizeName(rr.Name),
+			RRType:    rr.Type,
+			Rdata:     rdata(rr),
+			FirstSeen: dl.PacketTime,
+			LastSeen:  dl.PacketTime,
+			Count:     p.weight,
+		}
+
+		if old, ok := p.entries[e.Key()]; ok {
+			old.Merge(e)
+		} else {
+			p.entries[e.Key()] = e
+		}
+	}
+}
+
+func rdata(rr types.RR) string {
+	switch {
+	case rr.Address != nil:
+		return rr.Address.String()
+	case rr.Target != "" && rr.Type != "HTTPS" && rr.Type != "SVCB":
+		return strings.ToLower(rr.Target)
+	}
+	return rr.Rdata
+}
+
+func (p *PassiveDns) flush() {
+	if len(p.entries) == 0 {
+		return
+	}
+
+	entries := make([]*pdns.Entry, 0, len(p.entries))
+	for _, e := range p.entries {
+		entries = append(entries, e)
+	}
+
+	if err := p.store.Append(entries); err != nil {
+		logger.Errorf("flush passive dns entries failed %s", err)
+	}
+	p.entries = map[string]*pdns.Entry{}
+}
@@ -0,0 +1,203 @@
+package rebinddetecter
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+const (
+	KindPrivateAnswer = "private_answer"
+	KindRebinding     = "rebinding"
+)
+
+var (
+	privateRanges = map[string][]string{
+		"rfc1918":    {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"},
+		"loopback":   {"127.0.0.0/8", "::1/128"},
+		"link_local": {"169.254.0.0/16", "fe80::/10"},
+		"ula":        {"fc00::/7"},
+	}
+
+	// names which never resolve on the public internet
+	localZones = []string{"localhost.", "local.", "internal.", "home.arpa."}
+)
+
+type Finding struct {
+	Kind     string
+	Response *types.Dnslog
+	Private  []string
+	Detail   string
+}
+
+// NewDetector flags responses resolving names outside the internal zones to
+// private addresses, and names whose answers flip between public and private
+// addresses within window.
+func NewDetector(internalZones []string, window time.Duration) *Detector {
+	d := &Detector{
+		window:  window,
+		zones:   map[string]struct{}{},
+		private: iptrie.New[string](),
+		names:   map[string]*seen{},
+	}
+
+	for _, z := range append(append([]string{}, localZones...), internalZones...) {
+		d.zones[normalize(z)] = struct{}{}
+	}
+	for category, ss := range privateRanges {
+		for _, s := range ss {
+			d.private.Insert(netip.MustParsePrefix(s), category)
+		}
+	}
+	return d
+}
+
+type Detector struct {
+	window    time.Duration
+	zones     map[string]struct{}
+	private   *iptrie.Trie[string]
+	names     map[string]*seen
+	sweepTime time.Time
+}
+
+type seen struct {
+	publicTime    time.Time
+	publicAddr    string
+	privateTime   time.Time
+	privateAddr   string
+	lastFlipAlert time.Time
+}
+
+func (d *Detector) Add(dl *types.Dnslog) []*Finding {
+	if !dl.Response || dl.Rcode != "NOERROR" {
+		return nil
+	}
+	d.sweep(dl.PacketTime)
+
+	if d.internal(dl.Domain) {
+		return nil
+	}
+
+	findings := []*Finding{}
+	privates, public := []string{}, ""
+	for _, rr := range dl.Answer {
+		if rr.Address == nil || (rr.Type != "A" && rr.Type != "AAAA") || d.internal(rr.Name) {
+			continue
+		}
+
+		addr := iptrie.FromIP(rr.Address)
+		if category, ok := d.private.Lookup(addr); ok {
+			privates = append(privates, addr.String()+" "+category)
+			d.mark(rr.Name, dl.PacketTime, "", addr.String())
+			continue
+		}
+		if addr.IsUnspecified() {
+			continue
+		}
+		if public == "" {
+			public = addr.String()
+		}
+		d.mark(rr.Name, dl.PacketTime, addr.String(), "")
+	}
+
+	if len(privates) > 0 {
+		findings = append(findings, &Finding{
+			Kind:     KindPrivateAnswer,
+			Response: dl,
+			Private:  privates,
+		})
+	}
+
+	for _, rr := range dl.Answer {
+		if f := d.flip(rr.Name, dl); f != nil {
+			findings = append(findings, f)
+			break
+		}
+	}
+	return findings
+}
+
+// internal reports whether the name is an internal zone or under one.
+func (d *Detector) internal(name string) bool {
+	name = normalize(name)
+	for i := 0; i < len(name); i++ {
+		if i > 0 && name[i-1] != '.' {
+			continue
+		}
+		if _, ok := d.zones[name[i:]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Detector) mark(name string, t time.Time, public, private string) {
+	name = normalize(name)
+	s, ok := d.names[name]
+	if !ok {
+		s = &seen{}
+		d.names[name] = s
+	}
+
+	if public != "" {
+		s.publicTime, s.publicAddr = t, public
+	}
+	if private != "" {
+		s.privateTime, s.privateAddr = t, private
+	}
+}
+
+// flip reports a name seen with both public and private addresses within the
+// window, once per window.
+func (d *Detector) flip(name string, dl *types.Dnslog) *Finding {
+	s, ok := d.names[normalize(name)]
+	if !ok || s.publicTime.IsZero() || s.privateTime.IsZero() {
+		return nil
+	}
+
+	gap := s.publicTime.Sub(s.privateTime)
+	if gap < 0 {
+		gap = -gap
+	}
+	if gap > d.window || dl.PacketTime.Sub(s.lastFlipAlert) <= d.window {
+		return nil
+	}
+	s.lastFlipAlert = dl.PacketTime
+
+	detail := fmt.Sprintf("%s public %s at %s private %s at %s", normalize(name),
+		s.publicAddr, s.publicTime.Local().Format("15:04:05.999"),
+		s.privateAddr, s.privateTime.Local().Format("15:04:05.999"))
+	return &Finding{
+		Kind:     KindRebinding,
+		Response: dl,
+		Detail:   detail,
+	}
+}
+
+// sweep drops the names not answered within the window, at most once per window.
+func (d *Detector) sweep(now time.Time) {
+	if now.Sub(d.sweepTime) < d.window {
+		return
+	}
+	d.sweepTime = now
+
+	for name, s := range d.names {
+		last := s.publicTime
+		if s.privateTime.After(last) {
+			last = s.privateTime
+		}
+		if now.Sub(last) > d.window && now.Sub(s.lastFlipAlert) > d.window {
+			delete(d.names, name)
+		}
+	}
+}
+
+func normalize(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
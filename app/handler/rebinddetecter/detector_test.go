@@ -0,0 +1,62 @@
+package rebinddetecter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/hiwyw/dnscap-go/app/types/dnslogtest"
+)
+
+func response(sec int, qname string, answer ...string) *types.Dnslog {
+	return dnslogtest.Packet(sec*1000, "10.0.0.53", "10.0.1.1", 53, 40000, 0, qname, true, answer...)
+}
+
+func TestPrivateAnswer(t *testing.T) {
+	d := NewDetector([]string{"Corp.Example.com"}, time.Minute)
+
+	cases := []struct {
+		dl      *types.Dnslog
+		private int
+	}{
+		{response(0, "www.example.com.", "www.example.com. 60 IN A 192.0.2.1"), 0},
+		{response(0, "a.example.net.", "a.example.net. 60 IN A 10.1.1.1"), 1},
+		{response(0, "b.example.net.", "b.example.net. 60 IN AAAA fd00::1", "b.example.net. 60 IN AAAA fe80::1"), 2},
+		{response(0, "c.example.net.", "c.example.net. 60 IN CNAME lb.corp.example.com.", "lb.corp.example.com. 60 IN A 10.1.1.1"), 0},
+		{response(0, "host.CORP.example.com.", "host.corp.example.com. 60 IN A 192.168.1.1"), 0},
+		{response(0, "printer.local.", "printer.local. 60 IN A 169.254.1.1"), 0},
+		{response(0, "blocked.example.net.", "blocked.example.net. 60 IN A 0.0.0.0"), 0},
+	}
+	for _, c := range cases {
+		fs := d.Add(c.dl)
+		if c.private == 0 && len(fs) != 0 {
+			t.Fatalf("%s should not be flagged but find %v", c.dl.Domain, dnslogtest.Kinds(fs))
+		}
+		if c.private > 0 && (len(fs) != 1 || fs[0].Kind != KindPrivateAnswer || len(fs[0].Private) != c.private) {
+			t.Fatalf("%s should find %d private addresses but find %v", c.dl.Domain, c.private, dnslogtest.Kinds(fs))
+		}
+	}
+}
+
+func TestRebinding(t *testing.T) {
+	d := NewDetector(nil, time.Minute)
+
+	if fs := d.Add(response(0, "evil.example.", "evil.example. 1 IN A 198.51.100.1")); len(fs) != 0 {
+		t.Fatalf("public answer should not be flagged but find %v", dnslogtest.Kinds(fs))
+	}
+	fs := d.Add(response(5, "evil.example.", "evil.example. 1 IN A 127.0.0.1"))
+	if len(fs) != 2 || fs[0].Kind != KindPrivateAnswer || fs[1].Kind != KindRebinding {
+		t.Fatalf("should find private answer and rebinding but find %v", dnslogtest.Kinds(fs))
+	}
+	if fs := d.Add(response(10, "evil.example.", "evil.example. 1 IN A 198.51.100.1")); len(fs) != 0 {
+		t.Fatalf("rebinding should alert once per window but find %v", dnslogtest.Kinds(fs))
+	}
+
+	d.Add(response(100, "slow.example.", "slow.example. 1 IN A 198.51.100.1"))
+	if fs := d.Add(response(300, "slow.example.", "slow.example. 1 IN A 10.0.0.1")); len(fs) != 1 || fs[0].Kind != KindPrivateAnswer {
+		t.Fatalf("flip outside window should only find private answer but find %v", dnslogtest.Kinds(fs))
+	}
+	if _, ok := d.names["evil.example."]; ok {
+		t.Fatalf("expired name should be swept")
+	}
+}
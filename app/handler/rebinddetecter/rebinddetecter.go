@@ -0,0 +1,100 @@
+package rebinddetecter
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/natefinch/lumberjack"
+)
+
+const (
+	taskChannelBuffer = 100
+	batchWriteTimeout = time.Second * 1
+)
+
+func New(filename string, internalZones []string, window time.Duration, classifier *handler.Classifier) *RebindDetecter {
+	r := &RebindDetecter{
+		detector:   NewDetector(internalZones, window),
+		classifier: classifier,
+		writer: &lumberjack.Logger{
+			Filename:   filename,
+			MaxSize:    50,
+			MaxBackups: 10,
+			MaxAge:     30,
+			Compress:   true,
+		},
+		taskCh:  make(chan *types.Dnslog, taskChannelBuffer),
+		closeCh: make(chan struct{}),
+	}
+	r.buffer = bufio.NewWriterSize(r.writer, 1024*8)
+
+	go r.loop()
+	return r
+}
+
+type RebindDetecter struct {
+	detector   *Detector
+	classifier *handler.Classifier
+	writer     *lumberjack.Logger
+	buffer     *bufio.Writer
+	taskCh     chan *types.Dnslog
+	closeCh    chan struct{}
+}
+
+func (r *RebindDetecter) Handle(dl *types.Dnslog) {
+	r.taskCh <- dl
+}
+
+func (r *RebindDetecter) Stop() {
+	close(r.taskCh)
+	<-r.closeCh
+	r.writer.Close()
+}
+
+func (r *RebindDetecter) loop() {
+	for {
+		select {
+		case dl, ok := <-r.taskCh:
+			if !ok {
+				r.buffer.Flush()
+				r.closeCh <- struct{}{}
+				logger.Infof("rebind detecter handler exiting")
+				return
+			}
+			// upstream answers reach the clients again on the client side
+			if r.classifier.IsRecursion(dl) {
+				continue
+			}
+			for _, f := range r.detector.Add(dl) {
+				r.write(f)
+			}
+		case <-time.After(batchWriteTimeout):
+			r.buffer.Flush()
+		}
+	}
+}
+
+func (r *RebindDetecter) write(f *Finding) {
+	dl := f.Response
+	ss := []string{
+		dl.PacketTime.Local().Format("2006-01-02 15:04:05.999999"),
+		f.Kind,
+		dl.SrcIP.String(),
+		dl.DstIP.String(),
+		strconv.Itoa(int(dl.SrcPort)),
+		strconv.Itoa(int(dl.DstPort)),
+		strconv.Itoa(int(dl.TransID)),
+		dl.Domain,
+		dl.QueryType,
+		strings.Join(f.Private, ";"),
+		f.Detail,
+	}
+	if _, err := r.buffer.WriteString(strings.Join(ss, "|") + "\n"); err != nil {
+		logger.Errorf("write file %s failed %s", r.writer.Filename, err)
+	}
+}
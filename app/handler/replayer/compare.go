@@ -0,0 +1,95 @@
+package replayer
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+const (
+	delayLess10ms   = "0-10ms"
+	delayLess100ms  = "10-100ms"
+	delayLess1000ms = "100-1000ms"
+	delayLess3000ms = "1000-3000ms"
+	delayMore3000ms = "3000ms+"
+)
+
+// diffAnswer returns records only in the original answer and records only in
+// the replayed answer, ignoring ttl and order.
+func diffAnswer(original, replayed types.RRs) ([]string, []string) {
+	count := map[string]int{}
+	for _, rr := range original.Keys() {
+		count[rr]++
+	}
+	for _, rr := range replayed.Keys() {
+		count[rr]--
+	}
+
+	missing, extra := []string{}, []string{}
+	for rr, c := range count {
+		for ; c > 0; c-- {
+			missing = append(missing, rr)
+		}
+		for ; c < 0; c++ {
+			extra = append(extra, rr)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return missing, extra
+}
+
+func NewLatency() *Latency {
+	return &Latency{
+		DelayCount: map[string]int{
+			delayLess10ms:   0,
+			delayLess100ms:  0,
+			delayLess1000ms: 0,
+			delayLess3000ms: 0,
+			delayMore3000ms: 0,
+		},
+	}
+}
+
+type Latency struct {
+	Count      int            `json:"count"`
+	DelayCount map[string]int `json:"delay_statistics"`
+	P50        float64        `json:"p50_ms"`
+	P90        float64        `json:"p90_ms"`
+	P99        float64        `json:"p99_ms"`
+	durations  []time.Duration
+}
+
+func (l *Latency) count(d time.Duration) {
+	l.Count++
+	l.durations = append(l.durations, d)
+
+	switch ms := d.Milliseconds(); {
+	case ms <= 10:
+		l.DelayCount[delayLess10ms]++
+	case ms <= 100:
+		l.DelayCount[delayLess100ms]++
+	case ms <= 1000:
+		l.DelayCount[delayLess1000ms]++
+	case ms <= 3000:
+		l.DelayCount[delayLess3000ms]++
+	default:
+		l.DelayCount[delayMore3000ms]++
+	}
+}
+
+func (l *Latency) summarize() {
+	if len(l.durations) == 0 {
+		return
+	}
+
+	sort.Slice(l.durations, func(i, j int) bool { return l.durations[i] < l.durations[j] })
+	percentile := func(p float64) float64 {
+		i := int(float64(len(l.durations)-1) * p)
+		return float64(l.durations[i].Microseconds()) / 1000
+	}
+	l.P50 = percentile(0.5)
+	l.P90 = percentile(0.9)
+	l.P99 = percentile(0.99)
+}
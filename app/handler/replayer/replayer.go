@@ -0,0 +1,354 @@
+package replayer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/miekg/dns"
+	"github.com/natefinch/lumberjack"
+)
+
+const (
+	taskChannelBuffer = 100
+	batchWriteTimeout = time.Second * 1
+
+	resultMatch          = "match"
+	resultRcodeMismatch  = "rcode_mismatch"
+	resultAnswerMismatch = "answer_mismatch"
+	resultUnanswered     = "unanswered"
+	resultTimeout        = "timeout"
+	resultError          = "error"
+
+	ednsUdpSize = 1232
+)
+
+type Option struct {
+	Target       string
+	Speed        float64
+	Timeout      time.Duration
+	Concurrency  int
+	Filename     string
+	DiffFilename string
+}
+
+// New replays the client queries of transaction records against the target
+// server. Speed 1 keeps the original query rate, 2 doubles it and 0 sends as
+// fast as concurrency allows.
+func New(opt Option, classifier *handler.Classifier) *Replayer {
+	if opt.Concurrency <= 0 {
+		opt.Concurrency = 1
+	}
+
+	r := &Replayer{
+		opt:        opt,
+		classifier: classifier,
+		client:     &dns.Client{Net: "udp", Timeout: opt.Timeout},
+		report: &Report{
+			Target:             opt.Target,
+			Speed:              opt.Speed,
+			RcodeMismatchCount: map[string]int{},
+			OriginalLatency:    NewLatency(),
+			ReplayLatency:      NewLatency(),
+		},
+		writer: &lumberjack.Logger{
+			Filename:   opt.DiffFilename,
+			MaxSize:    50,
+			MaxBackups: 10,
+			MaxAge:     30,
+			Compress:   true,
+		},
+		sem:     make(chan struct{}, opt.Concurrency),
+		taskCh:  make(chan *types.Dnslog, taskChannelBuffer),
+		closeCh: make(chan struct{}),
+	}
+	r.buffer = bufio.NewWriterSize(r.writer, 1024*8)
+
+	go r.loop()
+	return r
+}
+
+type Replayer struct {
+	opt        Option
+	classifier *handler.Classifier
+	client     *dns.Client
+	baseTime   time.Time
+	startTime  time.Time
+	mu         sync.Mutex
+	report     *Report
+	writer     *lumberjack.Logger
+	buffer     *bufio.Writer
+	wg         sync.WaitGroup
+	sem        chan struct{}
+	taskCh     chan *types.Dnslog
+	closeCh    chan struct{}
+}
+
+type Report struct {
+	Target             string         `json:"target"`
+	Speed              float64        `json:"speed"`
+	BeginTime          time.Time      `json:"begin_time"`
+	EndTime            time.Time      `json:"end_time"`
+	Sent               int            `json:"sent"`
+	Answered           int            `json:"answered"`
+	Timeout            int            `json:"timeout"`
+	Error              int            `json:"error"`
+	OriginalUnanswered int            `json:"original_unanswered"`
+	Match              int            `json:"match"`
+	RcodeMismatch      int            `json:"rcode_mismatch"`
+	AnswerMismatch     int            `json:"answer_mismatch"`
+	RcodeMismatchCount map[string]int `json:"rcode_mismatch_statistics"`
+	OriginalLatency    *Latency       `json:"original_latency"`
+	ReplayLatency      *Latency       `json:"replay_latency"`
+}
+
+type result struct {
+	tx      *types.Dnslog
+	replay  *types.Dnslog
+	latency time.Duration
+	status  string
+	missing []string
+	extra   []string
+}
+
+func (r *Replayer) Handle(dl *types.Dnslog) {
+	r.taskCh <- dl
+}
+
+func (r *Replayer) Stop() {
+	close(r.taskCh)
+	<-r.closeCh
+	r.writer.Close()
+}
+
+func (r *Replayer) Report() *Report {
+	return r.report
+}
+
+func (r *Replayer) loop() {
+	flushTicker := time.NewTicker(batchWriteTimeout)
+	defer flushTicker.Stop()
+
+	for {
+		select {
+		case tx, ok := <-r.taskCh:
+			if !ok {
+				r.wg.Wait()
+				r.out()
+				r.closeCh <- struct{}{}
+				logger.Infof("replayer handler exiting")
+				return
+			}
+			r.replay(tx)
+		case <-flushTicker.C:
+			r.mu.Lock()
+			r.buffer.Flush()
+			r.mu.Unlock()
+		}
+	}
+}
+
+func (r *Replayer) replay(tx *types.Dnslog) {
+	if tx.Status == types.TransactionOrphan {
+		return
+	}
+
+	if r.classifier.HasSelfIps() && r.classifier.TransactionSide(tx) != handler.SideClient {
+		return
+	}
+
+	m, err := newQuery(tx)
+	if err != nil {
+		logger.Debugf("replay query %s %s skipped %s", tx.Domain, tx.QueryType, err)
+		return
+	}
+
+	r.wait(tx.QueryTime)
+	r.sem <- struct{}{}
+	r.wg.Add(1)
+	go func() {
+		defer func() {
+			<-r.sem
+			r.wg.Done()
+		}()
+		r.count(r.exchange(tx, m))
+	}()
+}
+
+func (r *Replayer) wait(t time.Time) {
+	if r.startTime.IsZero() {
+		r.startTime = time.Now()
+		r.baseTime = t
+		return
+	}
+
+	if r.opt.Speed <= 0 {
+		return
+	}
+
+	due := r.startTime.Add(time.Duration(float64(t.Sub(r.baseTime)) / r.opt.Speed))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func newQuery(tx *types.Dnslog) (*dns.Msg, error) {
+	qtype, ok := dns.StringToType[tx.QueryType]
+	if !ok {
+		return nil, errors.New("unknown query type")
+	}
+
+	qclass, ok := dns.StringToClass[tx.QueryClass]
+	if !ok {
+		return nil, errors.New("unknown query class")
+	}
+
+	m := new(dns.Msg)
+	m.Id = dns.Id()
+	m.RecursionDesired = tx.RecursionDesired
+	m.CheckingDisabled = tx.CheckingDisabled
+	m.Question = []dns.Question{{Name: dns.Fqdn(tx.Domain), Qtype: qtype, Qclass: qclass}}
+
+	for _, rr := range tx.Additional {
+		if rr.Type == "OPT" {
+			m.SetEdns0(ednsUdpSize, strings.Contains(rr.Rdata, "flags: do"))
+			break
+		}
+	}
+	return m, nil
+}
+
+func (r *Replayer) exchange(tx *types.Dnslog, m *dns.Msg) *result {
+	res := &result{tx: tx}
+
+	resp, rtt, err := r.client.Exchange(m, r.opt.Target)
+	res.latency = rtt
+	if err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			res.status = resultTimeout
+		} else {
+			res.status = resultError
+			logger.Debugf("replay query %s %s failed %s", tx.Domain, tx.QueryType, err)
+		}
+		return res
+	}
+
+	res.replay = &types.Dnslog{}
+	types.DnslogFromMsg(resp, res.replay)
+
+	switch {
+	case tx.Status == types.TransactionUnanswered:
+		res.status = resultUnanswered
+	case tx.Rcode != res.replay.Rcode:
+		res.status = resultRcodeMismatch
+	default:
+		res.missing, res.extra = diffAnswer(tx.Answer, res.replay.Answer)
+		if len(res.missing) > 0 || len(res.extra) > 0 {
+			res.status = resultAnswerMismatch
+		} else {
+			res.status = resultMatch
+		}
+	}
+	return res
+}
+
+func (r *Replayer) count(res *result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.report.Sent++
+	if res.tx.Status == types.TransactionAnswered {
+		r.report.OriginalLatency.count(res.tx.ResolvDuration)
+	}
+
+	switch res.status {
+	case resultTimeout:
+		r.report.Timeout++
+	case resultError:
+		r.report.Error++
+	default:
+		r.report.Answered++
+		r.report.ReplayLatency.count(res.latency)
+	}
+
+	switch res.status {
+	case resultUnanswered, resultTimeout, resultError:
+		if res.tx.Status == types.TransactionUnanswered {
+			r.report.OriginalUnanswered++
+		}
+		if res.status != resultUnanswered {
+			r.write(res)
+		}
+		return
+	case resultMatch:
+		r.report.Match++
+		return
+	case resultRcodeMismatch:
+		r.report.RcodeMismatch++
+		r.report.RcodeMismatchCount[res.tx.Rcode+"->"+res.replay.Rcode]++
+	case resultAnswerMismatch:
+		r.report.AnswerMismatch++
+	}
+	r.write(res)
+}
+
+func (r *Replayer) write(res *result) {
+	rcode := ""
+	answer := types.RRs{}
+	if res.replay != nil {
+		rcode = res.replay.Rcode
+		answer = res.replay.Answer
+	}
+
+	ss := []string{
+		res.tx.QueryTime.Local().Format("2006-01-02 15:04:05.999999"),
+		res.tx.SrcIP.String(),
+		res.tx.Domain,
+		res.tx.QueryType,
+		res.status,
+		res.tx.Rcode,
+		rcode,
+		strconv.FormatInt(res.tx.ResolvDuration.Microseconds(), 10),
+		strconv.FormatInt(res.latency.Microseconds(), 10),
+		res.tx.Answer.String(),
+		answer.String(),
+		strings.Join(res.missing, ";"),
+		strings.Join(res.extra, ";"),
+	}
+	if _, err := r.buffer.WriteString(strings.Join(ss, "|") + "\n"); err != nil {
+		logger.Errorf("write file %s failed %s", r.writer.Filename, err)
+	}
+}
+
+func (r *Replayer) out() {
+	r.buffer.Flush()
+
+	r.report.BeginTime = r.startTime
+	r.report.EndTime = time.Now()
+	r.report.OriginalLatency.summarize()
+	r.report.ReplayLatency.summarize()
+
+	logger.Infof("replay to %s sent %d answered %d timeout %d match %d rcode mismatch %d answer mismatch %d",
+		r.opt.Target, r.report.Sent, r.report.Answered, r.report.Timeout,
+		r.report.Match, r.report.RcodeMismatch, r.report.AnswerMismatch)
+
+	b, err := json.MarshalIndent(r.report, "", "    ")
+	if err != nil {
+		logger.Errorf("replay result marshal to json failed %s", err)
+		return
+	}
+
+	if err := os.WriteFile(r.opt.Filename, b, 0644); err != nil {
+		logger.Errorf("write file %s failed %s", r.opt.Filename, err)
+	}
+}
@@ -0,0 +1,121 @@
+package replayer
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/miekg/dns"
+)
+
+func startServer(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn:        pc,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(req)
+			switch req.Question[0].Name {
+			case "www.example.com.":
+				rr, _ := dns.NewRR("www.example.com. 60 IN A 192.0.2.1")
+				m.Answer = append(m.Answer, rr)
+			case "changed.example.com.":
+				rr, _ := dns.NewRR("changed.example.com. 60 IN A 192.0.2.2")
+				m.Answer = append(m.Answer, rr)
+			default:
+				m.Rcode = dns.RcodeNameError
+			}
+			w.WriteMsg(m)
+		}),
+	}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	<-started
+	return pc.LocalAddr().String()
+}
+
+func parseRRs(t *testing.T, ss ...string) types.RRs {
+	rrs := types.RRs{}
+	for _, s := range ss {
+		rr, err := types.ParseRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+func transaction(domain, rcode string, answer types.RRs) *types.Dnslog {
+	return &types.Dnslog{
+		QueryTime:        time.Now(),
+		SrcIP:            net.ParseIP("10.0.0.1"),
+		DstIP:            net.ParseIP("10.0.0.53"),
+		SrcPort:          40000,
+		DstPort:          53,
+		Domain:           domain,
+		QueryType:        "A",
+		QueryClass:       "IN",
+		RecursionDesired: true,
+		Response:         true,
+		Status:           types.TransactionAnswered,
+		Rcode:            rcode,
+		ResolvDuration:   5 * time.Millisecond,
+		Answer:           answer,
+	}
+}
+
+func TestReplay(t *testing.T) {
+	target := startServer(t)
+	c := handler.NewClassifier([]netip.Prefix{netip.MustParsePrefix("10.0.0.53/32")}, []uint16{53}, false)
+	r := New(Option{Target: target, Timeout: time.Second}, c)
+
+	txs := []*types.Dnslog{
+		transaction("www.example.com.", "NOERROR", parseRRs(t, "www.example.com. 300 IN A 192.0.2.1")),
+		transaction("changed.example.com.", "NOERROR", parseRRs(t, "changed.example.com. 300 IN A 192.0.2.1")),
+		transaction("gone.example.com.", "NOERROR", parseRRs(t, "gone.example.com. 300 IN A 192.0.2.1")),
+	}
+	for _, tx := range txs {
+		m, err := newQuery(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.count(r.exchange(tx, m))
+	}
+
+	rp := r.Report()
+	if rp.Sent != 3 || rp.Answered != 3 || rp.Match != 1 || rp.AnswerMismatch != 1 || rp.RcodeMismatch != 1 {
+		t.Fatalf("unexpected report %+v", rp)
+	}
+	if rp.RcodeMismatchCount["NOERROR->NXDOMAIN"] != 1 {
+		t.Fatalf("unexpected rcode mismatch statistics %v", rp.RcodeMismatchCount)
+	}
+}
+
+func TestDiffAnswer(t *testing.T) {
+	original := parseRRs(t,
+		"www.example.com. 300 IN CNAME cdn.example.net.",
+		"cdn.example.net. 60 IN A 192.0.2.1",
+	)
+	replayed := parseRRs(t,
+		"cdn.example.net. 20 IN A 192.0.2.1",
+		"WWW.example.com. 100 IN CNAME cdn.example.net.",
+	)
+	if missing, extra := diffAnswer(original, replayed); len(missing) != 0 || len(extra) != 0 {
+		t.Fatalf("answers should equal ignoring ttl and order but missing %v extra %v", missing, extra)
+	}
+
+	missing, extra := diffAnswer(original[:1], original[1:])
+	if len(missing) != 1 || len(extra) != 1 {
+		t.Fatalf("should find 1 missing and 1 extra record but missing %v extra %v", missing, extra)
+	}
+}
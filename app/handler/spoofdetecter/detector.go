@@ -0,0 +1,222 @@
+package spoofdetecter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+const (
+	KindUnsolicited        = "unsolicited"
+	KindWrongSource        = "wrong_source"
+	KindTransIdBurst       = "transid_burst"
+	KindConflictingAnswer  = "conflicting_answer"
+	KindQuestionMismatch   = "question_mismatch"
+	KindWrongTransId       = "wrong_transid"
+	defaultTransIdBurstMin = 3
+)
+
+type Finding struct {
+	Kind     string
+	Response *types.Dnslog
+	Query    *types.Dnslog
+	Detail   string
+}
+
+// NewDetector tracks the queries waiting for a response and the responses
+// matched within timeout. A response that matches neither is checked against
+// the queries of the same client port and question, which tells a spoofed
+// source address or a guessed TransID from a response nobody asked for.
+func NewDetector(timeout time.Duration, burstMin int) *Detector {
+	if burstMin <= 0 {
+		burstMin = defaultTransIdBurstMin
+	}
+
+	return &Detector{
+		timeout:  timeout,
+		burstMin: burstMin,
+		pending:  map[string]*pending{},
+		answered: map[string]*answered{},
+		byClient: map[string]map[*pending]struct{}{},
+		Counts:   map[string]int{},
+	}
+}
+
+type Detector struct {
+	timeout  time.Duration
+	burstMin int
+	pending  map[string]*pending
+	answered map[string]*answered
+	byClient map[string]map[*pending]struct{}
+	queue    []*expiry
+	Counts   map[string]int
+}
+
+type pending struct {
+	key       string
+	clientKey string
+	query     *types.Dnslog
+	wrongIds  int
+	reported  bool
+}
+
+type answered struct {
+	response *types.Dnslog
+	answers  string
+}
+
+type expiry struct {
+	key      string
+	time     time.Time
+	pending  *pending
+	answered *answered
+}
+
+func (d *Detector) Add(dl *types.Dnslog) []*Finding {
+	d.expire(dl.PacketTime)
+
+	if !dl.Response {
+		d.addQuery(dl)
+		return nil
+	}
+
+	f := d.checkResponse(dl)
+	if f == nil {
+		return nil
+	}
+	d.Counts[f.Kind]++
+	return []*Finding{f}
+}
+
+func (d *Detector) addQuery(dl *types.Dnslog) {
+	k := exactKey(dl.SrcIP.String(), dl.SrcPort, dl.DstIP.String(), dl.DstPort, dl.TransID)
+	if old, ok := d.pending[k]; ok {
+		d.removePending(old)
+	}
+	delete(d.answered, k)
+
+	p := &pending{
+		key:       k,
+		clientKey: clientKey(dl.SrcIP.String(), dl.SrcPort, dl.Domain, dl.QueryType),
+		query:     dl,
+	}
+	d.pending[k] = p
+	cs, ok := d.byClient[p.clientKey]
+	if !ok {
+		cs = map[*pending]struct{}{}
+		d.byClient[p.clientKey] = cs
+	}
+	cs[p] = struct{}{}
+	d.queue = append(d.queue, &expiry{key: k, time: dl.PacketTime, pending: p})
+}
+
+func (d *Detector) checkResponse(dl *types.Dnslog) *Finding {
+	k := exactKey(dl.DstIP.String(), dl.DstPort, dl.SrcIP.String(), dl.SrcPort, dl.TransID)
+
+	if p, ok := d.pending[k]; ok {
+		if !sameQuestion(p.query, dl) {
+			return &Finding{
+				Kind:     KindQuestionMismatch,
+				Response: dl,
+				Query:    p.query,
+				Detail:   fmt.Sprintf("queried %s %s", p.query.Domain, p.query.QueryType),
+			}
+		}
+
+		d.removePending(p)
+		a := &answered{response: dl, answers: answerKey(dl)}
+		d.answered[k] = a
+		d.queue = append(d.queue, &expiry{key: k, time: dl.PacketTime, answered: a})
+		return nil
+	}
+
+	if a, ok := d.answered[k]; ok {
+		if a.answers == answerKey(dl) {
+			return nil
+		}
+		return &Finding{
+			Kind:     KindConflictingAnswer,
+			Response: dl,
+			Detail:   fmt.Sprintf("first %s [%s] now %s [%s]", a.response.Rcode, a.response.Answer, dl.Rcode, dl.Answer),
+		}
+	}
+
+	// a matching transid from another source is checked against every query
+	// of the client before any of them is charged with a wrong transid
+	var first *pending
+	for p := range d.byClient[clientKey(dl.DstIP.String(), dl.DstPort, dl.Domain, dl.QueryType)] {
+		if p.query.TransID == dl.TransID {
+			return &Finding{
+				Kind:     KindWrongSource,
+				Response: dl,
+				Query:    p.query,
+				Detail:   fmt.Sprintf("queried %s:%d", p.query.DstIP, p.query.DstPort),
+			}
+		}
+		if first == nil || p.query.PacketTime.Before(first.query.PacketTime) {
+			first = p
+		}
+	}
+
+	if p := first; p != nil {
+		p.wrongIds++
+		d.Counts[KindWrongTransId]++
+		if p.wrongIds < d.burstMin || p.reported {
+			return nil
+		}
+		p.reported = true
+		return &Finding{
+			Kind:     KindTransIdBurst,
+			Response: dl,
+			Query:    p.query,
+			Detail:   fmt.Sprintf("%d responses with wrong transid, queried transid %d", p.wrongIds, p.query.TransID),
+		}
+	}
+
+	return &Finding{Kind: KindUnsolicited, Response: dl}
+}
+
+func (d *Detector) removePending(p *pending) {
+	delete(d.pending, p.key)
+	if cs, ok := d.byClient[p.clientKey]; ok {
+		delete(cs, p)
+		if len(cs) == 0 {
+			delete(d.byClient, p.clientKey)
+		}
+	}
+}
+
+func (d *Detector) expire(now time.Time) {
+	for len(d.queue) > 0 {
+		e := d.queue[0]
+		if !e.time.Add(d.timeout).Before(now) {
+			return
+		}
+		d.queue = d.queue[1:]
+
+		if e.pending != nil && d.pending[e.key] == e.pending {
+			d.removePending(e.pending)
+		}
+		if e.answered != nil && d.answered[e.key] == e.answered {
+			delete(d.answered, e.key)
+		}
+	}
+}
+
+func exactKey(clientIp string, clientPort uint16, serverIp string, serverPort uint16, id uint16) string {
+	return fmt.Sprintf("%s|%d|%s|%d|%d", clientIp, clientPort, serverIp, serverPort, id)
+}
+
+func clientKey(clientIp string, clientPort uint16, domain, qtype string) string {
+	return fmt.Sprintf("%s|%d|%s|%s", clientIp, clientPort, strings.ToLower(domain), qtype)
+}
+
+func sameQuestion(query, response *types.Dnslog) bool {
+	return strings.EqualFold(query.Domain, response.Domain) && query.QueryType == response.QueryType
+}
+
+func answerKey(dl *types.Dnslog) string {
+	return dl.Rcode + "|" + strings.Join(dl.Answer.Keys(), ";")
+}
@@ -0,0 +1,70 @@
+package spoofdetecter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/types/dnslogtest"
+)
+
+const (
+	resolver = "10.0.0.53"
+	upstream = "198.51.100.1"
+	attacker = "203.0.113.66"
+)
+
+func TestDetector(t *testing.T) {
+	d := NewDetector(5*time.Second, 3)
+
+	d.Add(dnslogtest.Packet(0, resolver, upstream, 40000, 53, 100, "www.example.com.", false))
+	for i := 0; i < 3; i++ {
+		fs := d.Add(dnslogtest.Packet(1+i, upstream, resolver, 53, 40000, uint16(200+i), "www.example.com.", true, "www.example.com. 300 IN A 203.0.113.66"))
+		if want := i == 2; (len(fs) == 1 && fs[0].Kind == KindTransIdBurst) != want {
+			t.Fatalf("response %d findings %v", i, dnslogtest.Kinds(fs))
+		}
+	}
+
+	if fs := d.Add(dnslogtest.Packet(5, attacker, resolver, 53, 40000, 100, "www.example.com.", true, "www.example.com. 300 IN A 203.0.113.66")); len(fs) != 1 || fs[0].Kind != KindWrongSource {
+		t.Fatalf("should find wrong source but find %v", dnslogtest.Kinds(fs))
+	}
+
+	if fs := d.Add(dnslogtest.Packet(10, upstream, resolver, 53, 40000, 100, "www.example.com.", true, "www.example.com. 300 IN A 192.0.2.1")); len(fs) != 0 {
+		t.Fatalf("matched response should be fine but find %v", dnslogtest.Kinds(fs))
+	}
+	if fs := d.Add(dnslogtest.Packet(11, upstream, resolver, 53, 40000, 100, "www.example.com.", true, "www.example.com. 60 IN A 192.0.2.1")); len(fs) != 0 {
+		t.Fatalf("duplicate response with same answer should be fine but find %v", dnslogtest.Kinds(fs))
+	}
+	if fs := d.Add(dnslogtest.Packet(12, upstream, resolver, 53, 40000, 100, "www.example.com.", true, "www.example.com. 300 IN A 203.0.113.66")); len(fs) != 1 || fs[0].Kind != KindConflictingAnswer {
+		t.Fatalf("should find conflicting answer but find %v", dnslogtest.Kinds(fs))
+	}
+
+	if fs := d.Add(dnslogtest.Packet(20, upstream, resolver, 53, 40001, 300, "www.example.com.", true)); len(fs) != 1 || fs[0].Kind != KindUnsolicited {
+		t.Fatalf("should find unsolicited response but find %v", dnslogtest.Kinds(fs))
+	}
+
+	if fs := d.Add(dnslogtest.Packet(6000, upstream, resolver, 53, 40000, 100, "www.example.com.", true, "www.example.com. 300 IN A 203.0.113.66")); len(fs) != 1 || fs[0].Kind != KindUnsolicited {
+		t.Fatalf("answered session should expire but find %v", dnslogtest.Kinds(fs))
+	}
+	if d.Counts[KindWrongTransId] != 3 || d.Counts[KindUnsolicited] != 2 {
+		t.Fatalf("unexpected counts %v", d.Counts)
+	}
+}
+
+func TestDetectorTwoPending(t *testing.T) {
+	other := "198.51.100.2"
+
+	// map order decides which query is seen first, so repeat a few times
+	for i := 0; i < 10; i++ {
+		d := NewDetector(5*time.Second, 3)
+		d.Add(dnslogtest.Packet(0, resolver, upstream, 40000, 53, 100, "www.example.com.", false))
+		d.Add(dnslogtest.Packet(1, resolver, other, 40000, 53, 101, "www.example.com.", false))
+
+		fs := d.Add(dnslogtest.Packet(2, attacker, resolver, 53, 40000, 101, "www.example.com.", true, "www.example.com. 300 IN A 203.0.113.66"))
+		if len(fs) != 1 || fs[0].Kind != KindWrongSource || fs[0].Query.TransID != 101 {
+			t.Fatalf("should find wrong source for transid 101 but find %v", dnslogtest.Kinds(fs))
+		}
+		if d.Counts[KindWrongTransId] != 0 {
+			t.Fatalf("should not count wrong transid but count %d", d.Counts[KindWrongTransId])
+		}
+	}
+}
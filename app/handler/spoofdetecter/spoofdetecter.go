@@ -0,0 +1,110 @@
+package spoofdetecter
+
+import (
+	"bufio"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/types"
+	"github.com/natefinch/lumberjack"
+)
+
+const (
+	taskChannelBuffer = 100
+	batchWriteTimeout = time.Second * 1
+)
+
+func New(filename string, timeout time.Duration, burstMin int) *SpoofDetecter {
+	s := &SpoofDetecter{
+		detector: NewDetector(timeout, burstMin),
+		writer: &lumberjack.Logger{
+			Filename:   filename,
+			MaxSize:    50,
+			MaxBackups: 10,
+			MaxAge:     30,
+			Compress:   true,
+		},
+		taskCh:  make(chan *types.Dnslog, taskChannelBuffer),
+		closeCh: make(chan struct{}),
+	}
+	s.buffer = bufio.NewWriterSize(s.writer, 1024*8)
+
+	go s.loop()
+	return s
+}
+
+type SpoofDetecter struct {
+	detector *Detector
+	writer   *lumberjack.Logger
+	buffer   *bufio.Writer
+	taskCh   chan *types.Dnslog
+	closeCh  chan struct{}
+}
+
+func (s *SpoofDetecter) Handle(dl *types.Dnslog) {
+	s.taskCh <- dl
+}
+
+func (s *SpoofDetecter) Stop() {
+	close(s.taskCh)
+	<-s.closeCh
+	s.writer.Close()
+}
+
+func (s *SpoofDetecter) loop() {
+	for {
+		select {
+		case dl, ok := <-s.taskCh:
+			if !ok {
+				s.buffer.Flush()
+				s.summary()
+				s.closeCh <- struct{}{}
+				logger.Infof("spoof detecter handler exiting")
+				return
+			}
+			for _, f := range s.detector.Add(dl) {
+				s.write(f)
+			}
+		case <-time.After(batchWriteTimeout):
+			s.buffer.Flush()
+		}
+	}
+}
+
+func (s *SpoofDetecter) write(f *Finding) {
+	r := f.Response
+	ss := []string{
+		r.PacketTime.Local().Format("2006-01-02 15:04:05.999999"),
+		f.Kind,
+		r.SrcIP.String(),
+		r.DstIP.String(),
+		strconv.Itoa(int(r.SrcPort)),
+		strconv.Itoa(int(r.DstPort)),
+		strconv.Itoa(int(r.TransID)),
+		r.Domain,
+		r.QueryType,
+		r.Rcode,
+		r.Answer.String(),
+		f.Detail,
+	}
+	if _, err := s.buffer.WriteString(strings.Join(ss, "|") + "\n"); err != nil {
+		logger.Errorf("write file %s failed %s", s.writer.Filename, err)
+	}
+}
+
+func (s *SpoofDetecter) summary() {
+	kinds := []string{}
+	for k := range s.detector.Counts {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+
+	ss := []string{}
+	for _, k := range kinds {
+		ss = append(ss, k+":"+strconv.Itoa(s.detector.Counts[k]))
+	}
+	logger.Infof("spoof detecter findings [%s]", strings.Join(ss, " "))
+}
@@ -0,0 +1,290 @@
+package correlate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+// Engine ties client side transactions of the observed resolver to the
+// recursion side queries it sent for the same name, the ancestors of that
+// name and the cname targets and ns names learned from upstream responses
+// while the client transaction was outstanding. Each upstream query is bound to
+// the earliest outstanding client transaction it relates to.
+func NewEngine(classifier *handler.Classifier, timeout time.Duration) *Engine {
+	return &Engine{
+		classifier: classifier,
+		timeout:    timeout,
+		pending:    map[string]*clientTx{},
+		related:    map[string]map[*clientTx]struct{}{},
+		upstream:   map[string]*clientTx{},
+	}
+}
+
+type Engine struct {
+	classifier *handler.Classifier
+	timeout    time.Duration
+	pending    map[string]*clientTx
+	related    map[string]map[*clientTx]struct{}
+	upstream   map[string]*clientTx
+	queue      []*clientTx
+	seq        int
+}
+
+type clientTx struct {
+	key            string
+	query          *types.Dnslog
+	names          []string
+	upstreamCount  int
+	upstreamNames  []string
+	upstreamWaits  []interval
+	upstreamAnswer bool
+	upstreamKeys   []string
+	seq            int
+	done           bool
+}
+
+type interval struct {
+	begin time.Time
+	end   time.Time
+}
+
+type Transaction struct {
+	Query             *types.Dnslog
+	Response          *types.Dnslog
+	CacheHit          bool
+	UpstreamQueries   int
+	UpstreamNames     []string
+	UpstreamWait      time.Duration
+	UpstreamAnswered  bool
+	ClientLatency     time.Duration
+	UpstreamWaitRatio float64
+}
+
+func (e *Engine) Add(dl *types.Dnslog) []*Transaction {
+	finished := e.expire(dl.PacketTime)
+
+	switch e.classifier.Side(dl) {
+	case handler.SideClient:
+		if dl.Response {
+			if tx, ok := e.pending[txKey(dl)]; ok {
+				finished = append(finished, e.finish(tx, dl))
+			}
+			return finished
+		}
+		e.begin(dl)
+	case handler.SideRecursion:
+		if dl.Response {
+			e.upstreamResponse(dl)
+		} else {
+			e.upstreamQuery(dl)
+		}
+	}
+	return finished
+}
+
+func (e *Engine) Flush() []*Transaction {
+	finished := []*Transaction{}
+	for _, tx := range e.queue {
+		if !tx.done {
+			finished = append(finished, e.finish(tx, nil))
+		}
+	}
+	e.queue = nil
+	return finished
+}
+
+func (e *Engine) begin(dl *types.Dnslog) {
+	key := txKey(dl)
+	if _, ok := e.pending[key]; ok {
+		return
+	}
+
+	e.seq++
+	tx := &clientTx{key: key, query: dl, seq: e.seq}
+	e.pending[key] = tx
+	e.queue = append(e.queue, tx)
+	e.register(tx, dl.Domain)
+}
+
+func (e *Engine) upstreamQuery(dl *types.Dnslog) {
+	name := strings.ToLower(dl.Domain)
+	tx := e.earliest(name)
+	if tx == nil {
+		return
+	}
+
+	key := txKey(dl)
+	if _, ok := e.upstream[key]; !ok {
+		e.upstream[key] = tx
+		tx.upstreamKeys = append(tx.upstreamKeys, key)
+	}
+	tx.upstreamCount++
+	tx.upstreamNames = appendUnique(tx.upstreamNames, name)
+}
+
+// earliest returns the first started outstanding transaction related to name.
+func (e *Engine) earliest(name string) *clientTx {
+	var first *clientTx
+	for tx := range e.related[name] {
+		if first == nil || tx.seq < first.seq {
+			first = tx
+		}
+	}
+	return first
+}
+
+func (e *Engine) upstreamResponse(dl *types.Dnslog) {
+	key := txKey(dl)
+	tx, ok := e.upstream[key]
+	if ok {
+		delete(e.upstream, key)
+	} else {
+		// the query was sent before the capture began
+		tx = e.earliest(strings.ToLower(dl.Domain))
+	}
+
+	if tx != nil {
+		if dl.ResolvDuration > 0 {
+			tx.upstreamWaits = append(tx.upstreamWaits, interval{
+				begin: dl.PacketTime.Add(-dl.ResolvDuration),
+				end:   dl.PacketTime,
+			})
+		}
+
+		for _, rr := range dl.Answer {
+			if rr.Type == "CNAME" || rr.Type == "DNAME" {
+				e.register(tx, rr.Target)
+			}
+			if strings.EqualFold(rr.Name, tx.query.Domain) && rr.Type == tx.query.QueryType {
+				tx.upstreamAnswer = true
+			}
+		}
+
+		for _, rr := range dl.Authority {
+			if rr.Type == "NS" {
+				e.register(tx, rr.Target)
+			}
+		}
+	}
+}
+
+func (e *Engine) register(tx *clientTx, name string) {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(name), "."), ".")
+	for i := 0; i == 0 || i < len(labels)-1; i++ {
+		n := strings.Join(labels[i:], ".") + "."
+		txs, ok := e.related[n]
+		if !ok {
+			txs = map[*clientTx]struct{}{}
+			e.related[n] = txs
+		}
+		if _, ok := txs[tx]; ok {
+			continue
+		}
+		txs[tx] = struct{}{}
+		tx.names = append(tx.names, n)
+	}
+}
+
+func (e *Engine) finish(tx *clientTx, response *types.Dnslog) *Transaction {
+	tx.done = true
+	delete(e.pending, tx.key)
+	for _, k := range tx.upstreamKeys {
+		if e.upstream[k] == tx {
+			delete(e.upstream, k)
+		}
+	}
+	for _, n := range tx.names {
+		delete(e.related[n], tx)
+		if len(e.related[n]) == 0 {
+			delete(e.related, n)
+		}
+	}
+
+	t := &Transaction{
+		Query:            tx.query,
+		Response:         response,
+		CacheHit:         tx.upstreamCount == 0,
+		UpstreamQueries:  tx.upstreamCount,
+		UpstreamNames:    tx.upstreamNames,
+		UpstreamAnswered: tx.upstreamAnswer,
+	}
+
+	end := tx.query.PacketTime.Add(e.timeout)
+	if response != nil {
+		end = response.PacketTime
+		t.ClientLatency = response.ResolvDuration
+	}
+	t.UpstreamWait = unionDuration(tx.upstreamWaits, tx.query.PacketTime, end)
+	if t.ClientLatency > 0 {
+		t.UpstreamWaitRatio = float64(t.UpstreamWait) / float64(t.ClientLatency)
+	}
+	return t
+}
+
+func (e *Engine) expire(now time.Time) []*Transaction {
+	finished := []*Transaction{}
+	for len(e.queue) > 0 {
+		tx := e.queue[0]
+		if !tx.done && !tx.query.PacketTime.Add(e.timeout).Before(now) {
+			break
+		}
+		e.queue = e.queue[1:]
+		if !tx.done {
+			finished = append(finished, e.finish(tx, nil))
+		}
+	}
+	return finished
+}
+
+func txKey(dl *types.Dnslog) string {
+	if dl.Response {
+		return fmt.Sprintf("%s|%d|%s|%d|%d", dl.DstIP, dl.DstPort, dl.SrcIP, dl.SrcPort, dl.TransID)
+	}
+	return fmt.Sprintf("%s|%d|%s|%d|%d", dl.SrcIP, dl.SrcPort, dl.DstIP, dl.DstPort, dl.TransID)
+}
+
+func unionDuration(intervals []interval, begin, end time.Time) time.Duration {
+	clipped := []interval{}
+	for _, i := range intervals {
+		if i.begin.Before(begin) {
+			i.begin = begin
+		}
+		if i.end.After(end) {
+			i.end = end
+		}
+		if i.end.After(i.begin) {
+			clipped = append(clipped, i)
+		}
+	}
+	sort.Slice(clipped, func(i, j int) bool {
+		return clipped[i].begin.Before(clipped[j].begin)
+	})
+
+	var total time.Duration
+	var cur interval
+	for i, c := range clipped {
+		if i == 0 || c.begin.After(cur.end) {
+			total += cur.end.Sub(cur.begin)
+			cur = c
+			continue
+		}
+		if c.end.After(cur.end) {
+			cur.end = c.end
+		}
+	}
+	return total + cur.end.Sub(cur.begin)
+}
+
+func appendUnique(ss []string, s string) []string {
+	for _, e := range ss {
+		if e == s {
+			return ss
+		}
+	}
+	return append(ss, s)
+}
@@ -0,0 +1,101 @@
+package correlate
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/handler"
+	"github.com/hiwyw/dnscap-go/app/types/dnslogtest"
+)
+
+const (
+	client   = "10.0.0.1"
+	resolver = "10.0.0.53"
+	upstream = "198.51.100.1"
+)
+
+func newEngine() *Engine {
+	c := handler.NewClassifier([]netip.Prefix{netip.MustParsePrefix("10.0.0.53/32")}, []uint16{53}, false)
+	return NewEngine(c, 5*time.Second)
+}
+
+func TestEngineCacheMiss(t *testing.T) {
+	e := newEngine()
+
+	e.Add(dnslogtest.Packet(0, client, resolver, 40000, 53, 1, "www.example.com.", false))
+	e.Add(dnslogtest.Packet(1, resolver, upstream, 50000, 53, 7, "www.example.com.", false))
+
+	resp := dnslogtest.Packet(21, upstream, resolver, 53, 50000, 7, "www.example.com.", true)
+	resp.ResolvDuration = 20 * time.Millisecond
+	resp.Answer = dnslogtest.RRs("www.example.com. 300 IN CNAME cdn.example.net.")
+	e.Add(resp)
+
+	e.Add(dnslogtest.Packet(22, resolver, upstream, 50001, 53, 8, "cdn.example.net.", false))
+	resp = dnslogtest.Packet(32, upstream, resolver, 53, 50001, 8, "cdn.example.net.", true)
+	resp.ResolvDuration = 10 * time.Millisecond
+	e.Add(resp)
+
+	final := dnslogtest.Packet(35, resolver, client, 53, 40000, 1, "www.example.com.", true)
+	final.ResolvDuration = 35 * time.Millisecond
+	txs := e.Add(final)
+	if len(txs) != 1 {
+		t.Fatalf("should finish 1 transaction but finish %d", len(txs))
+	}
+
+	tx := txs[0]
+	if tx.CacheHit || tx.UpstreamQueries != 2 || tx.UpstreamWait != 30*time.Millisecond {
+		t.Fatalf("unexpected transaction hit:%v upstream:%d wait:%s", tx.CacheHit, tx.UpstreamQueries, tx.UpstreamWait)
+	}
+}
+
+func TestEngineCacheHitAndTimeout(t *testing.T) {
+	e := newEngine()
+
+	e.Add(dnslogtest.Packet(0, client, resolver, 40000, 53, 1, "www.example.com.", false))
+	final := dnslogtest.Packet(1, resolver, client, 53, 40000, 1, "www.example.com.", true)
+	final.ResolvDuration = time.Millisecond
+	txs := e.Add(final)
+	if len(txs) != 1 || !txs[0].CacheHit || txs[0].UpstreamWait != 0 {
+		t.Fatalf("should finish as cache hit but got %+v", txs)
+	}
+
+	e.Add(dnslogtest.Packet(10, client, resolver, 40001, 53, 2, "slow.example.com.", false))
+	txs = e.Add(dnslogtest.Packet(6000, client, resolver, 40002, 53, 3, "other.example.com.", false))
+	if len(txs) != 1 || txs[0].Response != nil {
+		t.Fatalf("should expire unanswered transaction but got %+v", txs)
+	}
+
+	if txs = e.Flush(); len(txs) != 1 {
+		t.Fatalf("should flush 1 transaction but flush %d", len(txs))
+	}
+}
+
+func TestEngineConcurrentClients(t *testing.T) {
+	e := newEngine()
+	other := "10.0.0.2"
+
+	// both clients ask for names under example.com. before either is answered
+	e.Add(dnslogtest.Packet(0, client, resolver, 40000, 53, 1, "a.example.com.", false))
+	e.Add(dnslogtest.Packet(1, other, resolver, 40001, 53, 2, "a.example.com.", false))
+
+	// the resolver goes upstream once, the earliest client owns the query
+	e.Add(dnslogtest.Packet(2, resolver, upstream, 50000, 53, 7, "a.example.com.", false))
+	resp := dnslogtest.Packet(22, upstream, resolver, 53, 50000, 7, "a.example.com.", true)
+	resp.ResolvDuration = 20 * time.Millisecond
+	e.Add(resp)
+
+	first := dnslogtest.Packet(23, resolver, client, 53, 40000, 1, "a.example.com.", true)
+	first.ResolvDuration = 23 * time.Millisecond
+	txs := e.Add(first)
+	if len(txs) != 1 || txs[0].CacheHit || txs[0].UpstreamQueries != 1 || txs[0].UpstreamWait != 20*time.Millisecond {
+		t.Fatalf("first client should own the upstream query but got %+v", txs)
+	}
+
+	second := dnslogtest.Packet(24, resolver, other, 53, 40001, 2, "a.example.com.", true)
+	second.ResolvDuration = 23 * time.Millisecond
+	txs = e.Add(second)
+	if len(txs) != 1 || !txs[0].CacheHit || txs[0].UpstreamQueries != 0 || txs[0].UpstreamWait != 0 {
+		t.Fatalf("second client should not be charged the upstream query but got %+v", txs)
+	}
+}
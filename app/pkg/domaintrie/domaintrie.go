@@ -0,0 +1,154 @@
+package domaintrie
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	KindExact    = "exact"
+	KindWildcard = "wildcard"
+	KindSubtree  = "subtree"
+)
+
+func New[V any]() *Trie[V] {
+	return &Trie[V]{root: &node[V]{}}
+}
+
+// Trie matches domain names against patterns, labels are stored from the
+// root down and compared case-insensitively.
+type Trie[V any] struct {
+	root *node[V]
+	size int
+}
+
+type node[V any] struct {
+	children map[string]*node[V]
+	exact    []V
+	wildcard []V
+	subtree  []V
+}
+
+// Parse returns the kind and the fqdn of a pattern. www.example.com. matches
+// the name only, *.example.com. matches the names under example.com. and
+// .example.com. matches example.com. and all names under it.
+func Parse(pattern string) (string, string, error) {
+	if pattern == "" {
+		return "", "", errors.New("empty domain pattern")
+	}
+
+	kind := KindExact
+	name := pattern
+	switch {
+	case strings.HasPrefix(pattern, "*."):
+		kind = KindWildcard
+		name = pattern[2:]
+	case strings.HasPrefix(pattern, ".") && pattern != ".":
+		kind = KindSubtree
+		name = pattern[1:]
+	}
+	if name == "" {
+		name = "."
+	}
+
+	name = strings.ToLower(dns.Fqdn(name))
+	if _, ok := dns.IsDomainName(name); !ok || strings.Contains(name, "*") || strings.Contains(name, "..") {
+		return "", "", fmt.Errorf("invalid domain pattern %s", pattern)
+	}
+	return kind, name, nil
+}
+
+func (t *Trie[V]) Insert(pattern string, v V) error {
+	kind, name, err := Parse(pattern)
+	if err != nil {
+		return err
+	}
+
+	n := t.root
+	labels := dns.SplitDomainName(name)
+	for i := len(labels) - 1; i >= 0; i-- {
+		if n.children == nil {
+			n.children = map[string]*node[V]{}
+		}
+		c, ok := n.children[labels[i]]
+		if !ok {
+			c = &node[V]{}
+			n.children[labels[i]] = c
+		}
+		n = c
+	}
+
+	switch kind {
+	case KindWildcard:
+		n.wildcard = append(n.wildcard, v)
+	case KindSubtree:
+		n.subtree = append(n.subtree, v)
+	default:
+		n.exact = append(n.exact, v)
+	}
+	t.size++
+	return nil
+}
+
+func (t *Trie[V]) Len() int {
+	return t.size
+}
+
+// Lookup returns the value of the most specific pattern matching the name, an
+// exact pattern wins over a subtree pattern of the same name.
+func (t *Trie[V]) Lookup(name string) (V, bool) {
+	var result V
+	found := false
+	t.walk(name, func(vs []V) {
+		result = vs[len(vs)-1]
+		found = true
+	})
+	return result, found
+}
+
+// Match returns the values of all patterns matching the name, from the least
+// specific to the most specific.
+func (t *Trie[V]) Match(name string) []V {
+	result := []V{}
+	t.walk(name, func(vs []V) {
+		result = append(result, vs...)
+	})
+	return result
+}
+
+func (t *Trie[V]) Contains(name string) bool {
+	_, ok := t.Lookup(name)
+	return ok
+}
+
+func (t *Trie[V]) walk(name string, fn func(vs []V)) {
+	if t.size == 0 {
+		return
+	}
+
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	n := t.root
+	for i := len(labels); ; i-- {
+		if len(n.subtree) > 0 {
+			fn(n.subtree)
+		}
+		if i == 0 {
+			if len(n.exact) > 0 {
+				fn(n.exact)
+			}
+			return
+		}
+		if len(n.wildcard) > 0 {
+			fn(n.wildcard)
+		}
+
+		c, ok := n.children[labels[i-1]]
+		if !ok {
+			return
+		}
+		n = c
+	}
+}
@@ -0,0 +1,71 @@
+package domaintrie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrieMatch(t *testing.T) {
+	tr := New[string]()
+	for _, p := range []string{"corp.example", "*.corp.example.", ".Corp.Example.", "www.corp.example.", "*.", "."} {
+		if err := tr.Insert(p, p); err != nil {
+			t.Fatalf("insert %s failed %s", p, err)
+		}
+	}
+
+	cases := []struct {
+		name    string
+		longest string
+		all     []string
+	}{
+		{"corp.example.", "corp.example", []string{"*.", ".Corp.Example.", "corp.example"}},
+		{"WwW.cOrP.eXaMpLe.", "www.corp.example.", []string{"*.", ".Corp.Example.", "*.corp.example.", "www.corp.example."}},
+		{"a.b.corp.example", "*.corp.example.", []string{"*.", ".Corp.Example.", "*.corp.example."}},
+		{"example.", "*.", []string{"*."}},
+		{"xcorp.example.", "*.", []string{"*."}},
+		{".", ".", []string{"."}},
+	}
+
+	for _, c := range cases {
+		v, ok := tr.Lookup(c.name)
+		if ok != (c.longest != "") || v != c.longest {
+			t.Fatalf("lookup %s got %q %v want %q", c.name, v, ok, c.longest)
+		}
+		if all := tr.Match(c.name); !reflect.DeepEqual(all, c.all) {
+			t.Fatalf("match %s got %v want %v", c.name, all, c.all)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		pattern string
+		kind    string
+		name    string
+	}{
+		{"www.Example.com", KindExact, "www.example.com."},
+		{"*.example.com.", KindWildcard, "example.com."},
+		{".example.com", KindSubtree, "example.com."},
+		{".", KindExact, "."},
+		{"*.", KindWildcard, "."},
+	}
+	for _, c := range cases {
+		kind, name, err := Parse(c.pattern)
+		if err != nil || kind != c.kind || name != c.name {
+			t.Fatalf("parse %s got %s %s %v want %s %s", c.pattern, kind, name, err, c.kind, c.name)
+		}
+	}
+
+	for _, p := range []string{"", "a.*.example.com.", "**.example.com.", "..example.com.", "www..example.com."} {
+		if _, _, err := Parse(p); err == nil {
+			t.Fatalf("parse %s should fail", p)
+		}
+	}
+}
+
+func TestTrieEmpty(t *testing.T) {
+	tr := New[int]()
+	if tr.Contains("www.example.com.") || len(tr.Match("www.example.com.")) != 0 {
+		t.Fatalf("empty trie should not match")
+	}
+}
@@ -0,0 +1,57 @@
+package iptrie
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// LoadGroups reads a csv file of group name and address rows, an address is
+// a single ip, a cidr or an address range and a row may list several of them.
+// Rows of the same group are merged, lines starting with # are skipped.
+func LoadGroups(path string) (map[string][]netip.Prefix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseGroups(f)
+}
+
+func ParseGroups(reader io.Reader) (map[string][]netip.Prefix, error) {
+	r := csv.NewReader(reader)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	groups := map[string][]netip.Prefix{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return groups, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := r.FieldPos(0)
+		name := strings.TrimSpace(record[0])
+		if name == "" || len(record) < 2 {
+			return nil, fmt.Errorf("line %d want group name and address", line)
+		}
+		for _, s := range record[1:] {
+			if strings.TrimSpace(s) == "" {
+				continue
+			}
+			ps, err := Parse(s)
+			if err != nil {
+				return nil, fmt.Errorf("line %d %s", line, err)
+			}
+			groups[name] = append(groups[name], ps...)
+		}
+	}
+}
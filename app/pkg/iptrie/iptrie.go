@@ -0,0 +1,130 @@
+package iptrie
+
+import (
+	"net"
+	"net/netip"
+)
+
+func New[V any]() *Trie[V] {
+	return &Trie[V]{
+		v4: &node[V]{},
+		v6: &node[V]{},
+	}
+}
+
+type Trie[V any] struct {
+	v4   *node[V]
+	v6   *node[V]
+	size int
+}
+
+type node[V any] struct {
+	children [2]*node[V]
+	values   []V
+}
+
+func (t *Trie[V]) Insert(p netip.Prefix, v V) {
+	p = normalize(p)
+	n := t.root(p.Addr())
+	for i := 0; i < p.Bits(); i++ {
+		b := bitAt(p.Addr(), i)
+		if n.children[b] == nil {
+			n.children[b] = &node[V]{}
+		}
+		n = n.children[b]
+	}
+	n.values = append(n.values, v)
+	t.size++
+}
+
+func (t *Trie[V]) Len() int {
+	return t.size
+}
+
+func (t *Trie[V]) Lookup(addr netip.Addr) (V, bool) {
+	var result V
+	found := false
+	t.walk(addr, func(n *node[V]) {
+		result = n.values[len(n.values)-1]
+		found = true
+	})
+	return result, found
+}
+
+func (t *Trie[V]) Match(addr netip.Addr) []V {
+	result := []V{}
+	t.walk(addr, func(n *node[V]) {
+		result = append(result, n.values...)
+	})
+	return result
+}
+
+func (t *Trie[V]) Contains(addr netip.Addr) bool {
+	_, ok := t.Lookup(addr)
+	return ok
+}
+
+func (t *Trie[V]) LookupIP(ip net.IP) (V, bool) {
+	return t.Lookup(FromIP(ip))
+}
+
+func (t *Trie[V]) MatchIP(ip net.IP) []V {
+	return t.Match(FromIP(ip))
+}
+
+func (t *Trie[V]) ContainsIP(ip net.IP) bool {
+	return t.Contains(FromIP(ip))
+}
+
+func (t *Trie[V]) walk(addr netip.Addr, fn func(n *node[V])) {
+	if !addr.IsValid() {
+		return
+	}
+	addr = addr.Unmap()
+
+	n := t.root(addr)
+	for i := 0; n != nil; i++ {
+		if len(n.values) > 0 {
+			fn(n)
+		}
+		if i == addr.BitLen() {
+			return
+		}
+		n = n.children[bitAt(addr, i)]
+	}
+}
+
+func (t *Trie[V]) root(addr netip.Addr) *node[V] {
+	if addr.Is4() {
+		return t.v4
+	}
+	return t.v6
+}
+
+func FromIP(ip net.IP) netip.Addr {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+func normalize(p netip.Prefix) netip.Prefix {
+	if p.Addr().Is4In6() {
+		bits := p.Bits() - 96
+		if bits < 0 {
+			bits = 0
+		}
+		p = netip.PrefixFrom(p.Addr().Unmap(), bits)
+	}
+	return p.Masked()
+}
+
+func bitAt(addr netip.Addr, i int) int {
+	if addr.Is4() {
+		b := addr.As4()
+		return int(b[i/8]>>(7-i%8)) & 1
+	}
+	b := addr.As16()
+	return int(b[i/8]>>(7-i%8)) & 1
+}
@@ -0,0 +1,120 @@
+package iptrie
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustParse(t *testing.T, s string) []netip.Prefix {
+	ps, err := Parse(s)
+	if err != nil {
+		t.Fatalf("parse %s failed %s", s, err)
+	}
+	return ps
+}
+
+func TestTrieMatch(t *testing.T) {
+	tr := New[string]()
+	for _, s := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.3", "2001:db8::/32"} {
+		for _, p := range mustParse(t, s) {
+			tr.Insert(p, s)
+		}
+	}
+
+	cases := []struct {
+		addr    string
+		longest string
+		all     []string
+	}{
+		{"10.1.2.3", "10.1.2.3", []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.3"}},
+		{"10.1.9.9", "10.1.0.0/16", []string{"10.0.0.0/8", "10.1.0.0/16"}},
+		{"10.200.0.1", "10.0.0.0/8", []string{"10.0.0.0/8"}},
+		{"::ffff:10.1.2.3", "10.1.2.3", []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.3"}},
+		{"2001:db8::1", "2001:db8::/32", []string{"2001:db8::/32"}},
+		{"192.168.1.1", "", []string{}},
+	}
+
+	for _, c := range cases {
+		addr := netip.MustParseAddr(c.addr)
+		v, ok := tr.Lookup(addr)
+		if ok != (c.longest != "") || v != c.longest {
+			t.Fatalf("lookup %s got %q %v want %q", c.addr, v, ok, c.longest)
+		}
+		if all := tr.Match(addr); !reflect.DeepEqual(all, c.all) {
+			t.Fatalf("match %s got %v want %v", c.addr, all, c.all)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	got := mustParse(t, "10.0.0.1-10.0.0.10")
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.1/32"),
+		netip.MustParsePrefix("10.0.0.2/31"),
+		netip.MustParsePrefix("10.0.0.4/30"),
+		netip.MustParsePrefix("10.0.0.8/31"),
+		netip.MustParsePrefix("10.0.0.10/32"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("range got %v want %v", got, want)
+	}
+
+	got = mustParse(t, "0.0.0.0-255.255.255.255")
+	if len(got) != 1 || got[0] != netip.MustParsePrefix("0.0.0.0/0") {
+		t.Fatalf("full range got %v", got)
+	}
+
+	for _, s := range []string{"10.0.0.9-10.0.0.1", "10.0.0.1-::1", "10.0.0.300", "10.0.0.0/33"} {
+		if _, err := Parse(s); err == nil {
+			t.Fatalf("parse %s should fail", s)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	ps := []netip.Prefix{}
+	for _, s := range []string{"10.0.0.0/25", "10.0.0.128/25", "10.0.0.7", "10.0.1.0/24", "192.168.0.1", "2001:db8::/33", "2001:db8:8000::/33"} {
+		ps = append(ps, mustParse(t, s)...)
+	}
+
+	got := Aggregate(ps)
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/23"),
+		netip.MustParsePrefix("192.168.0.1/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("aggregate got %v want %v", got, want)
+	}
+}
+
+func TestParseGroups(t *testing.T) {
+	input := `# group,address
+sales, 10.1.0.0/16, 10.9.0.1
+"site b",10.2.0.1-10.2.0.2
+sales,2001:db8::/32
+`
+	got, err := ParseGroups(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse groups failed %s", err)
+	}
+	want := map[string][]netip.Prefix{
+		"sales": {
+			netip.MustParsePrefix("10.1.0.0/16"),
+			netip.MustParsePrefix("10.9.0.1/32"),
+			netip.MustParsePrefix("2001:db8::/32"),
+		},
+		"site b": {netip.MustParsePrefix("10.2.0.1/32"), netip.MustParsePrefix("10.2.0.2/32")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("groups got %v want %v", got, want)
+	}
+
+	for _, s := range []string{"sales\n", "sales,10.0.0.300\n", ",10.0.0.1\n"} {
+		if _, err := ParseGroups(strings.NewReader(s)); err == nil {
+			t.Fatalf("parse groups %q should fail", s)
+		}
+	}
+}
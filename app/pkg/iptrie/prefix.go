@@ -0,0 +1,126 @@
+package iptrie
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Parse accepts a single address, a cidr or an inclusive address range
+// written as "from-to", and returns the prefixes covering it.
+func Parse(s string) ([]netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+
+	if from, to, ok := strings.Cut(s, "-"); ok {
+		start, err := netip.ParseAddr(strings.TrimSpace(from))
+		if err != nil {
+			return nil, fmt.Errorf("parse range %s failed %s", s, err)
+		}
+		end, err := netip.ParseAddr(strings.TrimSpace(to))
+		if err != nil {
+			return nil, fmt.Errorf("parse range %s failed %s", s, err)
+		}
+		return RangeToPrefixes(start, end)
+	}
+
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("parse cidr %s failed %s", s, err)
+		}
+		return []netip.Prefix{normalize(p)}, nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return nil, fmt.Errorf("parse ip %s failed %s", s, err)
+	}
+	addr = addr.Unmap()
+	return []netip.Prefix{netip.PrefixFrom(addr, addr.BitLen())}, nil
+}
+
+func RangeToPrefixes(start, end netip.Addr) ([]netip.Prefix, error) {
+	start, end = start.Unmap(), end.Unmap()
+	if start.Is4() != end.Is4() {
+		return nil, fmt.Errorf("range %s-%s mixes address families", start, end)
+	}
+	if end.Less(start) {
+		return nil, fmt.Errorf("range %s-%s end before start", start, end)
+	}
+
+	result := []netip.Prefix{}
+	for {
+		bits := start.BitLen()
+		for bits > 0 {
+			p := netip.PrefixFrom(start, bits-1).Masked()
+			if p.Addr() != start || end.Less(LastAddr(p)) {
+				break
+			}
+			bits--
+		}
+
+		p := netip.PrefixFrom(start, bits)
+		result = append(result, p)
+
+		last := LastAddr(p)
+		if !last.Less(end) {
+			return result, nil
+		}
+		start = last.Next()
+	}
+}
+
+// Aggregate drops prefixes covered by others and merges sibling prefixes,
+// so the result covers exactly the same addresses with as few entries as possible.
+func Aggregate(prefixes []netip.Prefix) []netip.Prefix {
+	t := New[struct{}]()
+	for _, p := range prefixes {
+		t.Insert(p, struct{}{})
+	}
+
+	_, v4 := collect(t.v4, netip.IPv4Unspecified(), 0)
+	_, v6 := collect(t.v6, netip.IPv6Unspecified(), 0)
+	return append(v4, v6...)
+}
+
+func collect[V any](n *node[V], addr netip.Addr, depth int) (bool, []netip.Prefix) {
+	if n == nil {
+		return false, nil
+	}
+
+	self := netip.PrefixFrom(addr, depth)
+	if len(n.values) > 0 {
+		return true, []netip.Prefix{self}
+	}
+
+	if depth == addr.BitLen() {
+		return false, nil
+	}
+
+	leftFull, left := collect(n.children[0], addr, depth+1)
+	rightFull, right := collect(n.children[1], setBit(addr, depth), depth+1)
+	if leftFull && rightFull {
+		return true, []netip.Prefix{self}
+	}
+	return false, append(left, right...)
+}
+
+// LastAddr returns the last address covered by the prefix.
+func LastAddr(p netip.Prefix) netip.Addr {
+	addr := p.Masked().Addr()
+	for i := p.Bits(); i < addr.BitLen(); i++ {
+		addr = setBit(addr, i)
+	}
+	return addr
+}
+
+func setBit(addr netip.Addr, i int) netip.Addr {
+	if addr.Is4() {
+		b := addr.As4()
+		b[i/8] |= 1 << (7 - i%8)
+		return netip.AddrFrom4(b)
+	}
+	b := addr.As16()
+	b[i/8] |= 1 << (7 - i%8)
+	return netip.AddrFrom16(b)
+}
@@ -0,0 +1,520 @@
+package pdns
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+)
+
+const (
+	dataFilename    = "pdns.data"
+	forwardFilename = "forward.idx"
+	reverseFilename = "reverse.idx"
+
+	runHeader = "#run\t"
+)
+
+// Entry is one observed (rrname, rrtype, rdata) tuple. The data file holds
+// the entries flushed by every interval, so the same tuple appears once per
+// interval it was seen in and is merged at query time.
+type Entry struct {
+	RRName    string    `json:"rrname"`
+	RRType    string    `json:"rrtype"`
+	Rdata     string    `json:"rdata"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Count     int       `json:"count"`
+}
+
+func (e *Entry) Key() string {
+	return e.RRName + "|" + e.RRType + "|" + e.Rdata
+}
+
+func (e *Entry) Merge(o *Entry) {
+	if o.FirstSeen.Before(e.FirstSeen) {
+		e.FirstSeen = o.FirstSeen
+	}
+	if o.LastSeen.After(e.LastSeen) {
+		e.LastSeen = o.LastSeen
+	}
+	e.Count += o.Count
+}
+
+// Store appends entries to the data file and every flushed batch as one sorted
+// run to the forward index, keyed by rrname, and to the reverse index, keyed
+// by rdata. A run starts with a header line holding its length, a lookup
+// binary searches each run instead of reading the whole index. Names are keyed
+// by their labels reversed so that the names under a domain are one key range,
+// addresses by their 16 byte form in hex so that a cidr is one as well.
+type Store struct {
+	dir     string
+	data    *os.File
+	forward *os.File
+	reverse *os.File
+	offset  int64
+}
+
+type indexLine struct {
+	key    string
+	offset int64
+}
+
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create pdns dir %s failed %s", dir, err)
+	}
+
+	s := &Store{dir: dir}
+	files := []**os.File{&s.data, &s.forward, &s.reverse}
+	for i, name := range []string{dataFilename, forwardFilename, reverseFilename} {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("open pdns file %s failed %s", name, err)
+		}
+		*files[i] = f
+	}
+
+	for _, f := range []*os.File{s.forward, s.reverse} {
+		if err := repairIndex(f); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("repair pdns index %s failed %s", f.Name(), err)
+		}
+	}
+
+	info, err := s.data.Stat()
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("stat pdns data file failed %s", err)
+	}
+	s.offset = info.Size()
+	return s, nil
+}
+
+func (s *Store) Append(entries []*Entry) error {
+	data := bufio.NewWriter(s.data)
+	forward := make([]indexLine, 0, len(entries))
+	reverse := make([]indexLine, 0, len(entries))
+
+	offset := s.offset
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("pdns entry marshal to json failed %s", err)
+		}
+		b = append(b, '\n')
+		data.Write(b)
+
+		forward = append(forward, indexLine{key: indexKey(e.RRName), offset: offset})
+		reverse = append(reverse, indexLine{key: indexKey(e.Rdata), offset: offset})
+		offset += int64(len(b))
+	}
+
+	// indexes are written after the data so that they never point past it
+	if err := data.Flush(); err != nil {
+		// part of the batch may have reached the file, take the offset from
+		// the file so that the next batch is still indexed correctly
+		if info, e := s.data.Stat(); e == nil {
+			s.offset = info.Size()
+		}
+		return fmt.Errorf("write pdns data file failed %s", err)
+	}
+	s.offset = offset
+	if err := appendRun(s.forward, forward); err != nil {
+		return fmt.Errorf("write pdns forward index failed %s", err)
+	}
+	if err := appendRun(s.reverse, reverse); err != nil {
+		return fmt.Errorf("write pdns reverse index failed %s", err)
+	}
+	return nil
+}
+
+// appendRun writes the lines sorted by key as one run, a run failing half way
+// is cut off so that the next one still starts at a header.
+func appendRun(f *os.File, lines []indexLine) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].key != lines[j].key {
+			return lines[i].key < lines[j].key
+		}
+		return lines[i].offset < lines[j].offset
+	})
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l.key)
+		b.WriteByte('\t')
+		b.WriteString(strconv.FormatInt(l.offset, 10))
+		b.WriteByte('\n')
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(runHeader + strconv.Itoa(b.Len()) + "\n" + b.String()); err != nil {
+		f.Truncate(info.Size())
+		return err
+	}
+	return nil
+}
+
+// repairIndex cuts off a run left incomplete by a crash.
+func repairIndex(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, end, err := readRuns(f, info.Size())
+	if err != nil {
+		return err
+	}
+	if end < info.Size() {
+		return f.Truncate(end)
+	}
+	return nil
+}
+
+func (s *Store) Close() error {
+	var err error
+	for _, f := range []*os.File{s.data, s.forward, s.reverse} {
+		if f == nil {
+			continue
+		}
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Query selects entries by rrname or by rdata. Name may start with "*." to
+// match all names under a domain; rdata may be a name, an address, a cidr or
+// an address range. Entries not seen within [Start, End] are dropped.
+type Query struct {
+	Name  string
+	Rdata string
+	Start time.Time
+	End   time.Time
+}
+
+func Lookup(dir string, q Query) ([]*Entry, error) {
+	var index string
+	var ranges []keyRange
+
+	switch {
+	case q.Name != "":
+		index = forwardFilename
+		ranges = nameRanges(q.Name)
+	case q.Rdata != "":
+		index = reverseFilename
+		var err error
+		if ranges, err = rdataRanges(q.Rdata); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("pdns query name and rdata both empty")
+	}
+
+	offsets, err := searchIndex(filepath.Join(dir, index), ranges)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := readEntries(filepath.Join(dir, dataFilename), offsets)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]*Entry{}
+	for _, e := range entries {
+		if m, ok := merged[e.Key()]; ok {
+			m.Merge(e)
+		} else {
+			merged[e.Key()] = e
+		}
+	}
+
+	result := []*Entry{}
+	for _, e := range merged {
+		if !q.Start.IsZero() && e.LastSeen.Before(q.Start) {
+			continue
+		}
+		if !q.End.IsZero() && e.FirstSeen.After(q.End) {
+			continue
+		}
+		result = append(result, e)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].LastSeen.Equal(result[j].LastSeen) {
+			return result[i].LastSeen.After(result[j].LastSeen)
+		}
+		return result[i].Key() < result[j].Key()
+	})
+	return result, nil
+}
+
+func NormalizeName(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+// keyRange selects the index keys from lo to hi inclusive.
+type keyRange struct {
+	lo string
+	hi string
+}
+
+// indexKey keys an address by its 16 byte form and anything else as a name.
+func indexKey(s string) string {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addrKey(addr)
+	}
+	return nameKey(s)
+}
+
+func addrKey(addr netip.Addr) string {
+	b := addr.As16()
+	return "ip:" + hex.EncodeToString(b[:])
+}
+
+// nameKey reverses the labels of the name, each followed by a dot, so the
+// names under a domain share the key of the domain as prefix.
+func nameKey(name string) string {
+	labels := dns.SplitDomainName(NormalizeName(name))
+	var b strings.Builder
+	b.WriteString("dn:")
+	for i := len(labels) - 1; i >= 0; i-- {
+		b.WriteString(labels[i])
+		b.WriteByte('.')
+	}
+	return b.String()
+}
+
+func nameRanges(name string) []keyRange {
+	if strings.HasPrefix(name, "*.") {
+		// the names under the domain, not the domain itself
+		k := nameKey(name[2:])
+		return []keyRange{{lo: k + "\x00", hi: k + "\xff"}}
+	}
+
+	k := nameKey(name)
+	return []keyRange{{lo: k, hi: k}}
+}
+
+func rdataRanges(rdata string) ([]keyRange, error) {
+	if prefixes, err := iptrie.Parse(rdata); err == nil {
+		ranges := []keyRange{}
+		for _, p := range prefixes {
+			ranges = append(ranges, keyRange{lo: addrKey(p.Masked().Addr()), hi: addrKey(iptrie.LastAddr(p))})
+		}
+		return ranges, nil
+	}
+
+	if _, ok := dns.IsDomainName(rdata); !ok {
+		return nil, fmt.Errorf("pdns query rdata %s not ip or domain name", rdata)
+	}
+	return nameRanges(rdata), nil
+}
+
+// run is the byte range of the sorted lines of one flush in an index file.
+type run struct {
+	start int64
+	end   int64
+}
+
+// readRuns returns the complete runs of an index file and where they end.
+func readRuns(f io.ReaderAt, size int64) ([]run, int64, error) {
+	runs := []run{}
+	pos := int64(0)
+	for pos < size {
+		line, err := readLine(f, pos, size)
+		if err != nil {
+			break
+		}
+		n, ok := strings.CutPrefix(line, runHeader)
+		if !ok {
+			return nil, 0, fmt.Errorf("no run header at %d", pos)
+		}
+		length, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid run header at %d", pos)
+		}
+
+		r := run{start: pos + int64(len(line)) + 1}
+		r.end = r.start + length
+		if r.end > size {
+			break
+		}
+		runs = append(runs, r)
+		pos = r.end
+	}
+	return runs, pos, nil
+}
+
+// searchIndex returns the data offsets of the index keys within the ranges,
+// each run is binary searched for the start of a range.
+func searchIndex(filename string, ranges []keyRange) ([]int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("open pdns index %s failed %s", filename, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat pdns index %s failed %s", filename, err)
+	}
+	runs, _, err := readRuns(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("read pdns index %s failed %s", filename, err)
+	}
+
+	offsets := []int64{}
+	for _, r := range runs {
+		for _, kr := range ranges {
+			pos, err := lowerBound(f, r, kr.lo)
+			if err != nil {
+				return nil, fmt.Errorf("search pdns index %s failed %s", filename, err)
+			}
+
+			reader := bufio.NewReader(io.NewSectionReader(f, pos, r.end-pos))
+			for {
+				line, err := reader.ReadString('\n')
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, fmt.Errorf("read pdns index %s failed %s", filename, err)
+				}
+				key, o, _ := strings.Cut(strings.TrimSuffix(line, "\n"), "\t")
+				if key > kr.hi {
+					break
+				}
+				offset, err := strconv.ParseInt(o, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid pdns index %s line %s", filename, line)
+				}
+				offsets = append(offsets, offset)
+			}
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets, nil
+}
+
+// lowerBound returns the start of the first line of the run with a key not
+// less than lo, or the end of the run.
+func lowerBound(f io.ReaderAt, r run, lo string) (int64, error) {
+	a, b := r.start, r.end
+	for a < b {
+		p, err := lineStart(f, r, a+(b-a)/2)
+		if err != nil {
+			return 0, err
+		}
+		if p >= b {
+			// what is left is shorter than two lines
+			for a < b {
+				line, err := readLine(f, a, r.end)
+				if err != nil {
+					return 0, err
+				}
+				if lineKey(line) >= lo {
+					return a, nil
+				}
+				a += int64(len(line)) + 1
+			}
+			return b, nil
+		}
+
+		line, err := readLine(f, p, r.end)
+		if err != nil {
+			return 0, err
+		}
+		if lineKey(line) >= lo {
+			b = p
+		} else {
+			a = p + int64(len(line)) + 1
+		}
+	}
+	return a, nil
+}
+
+// lineStart returns the start of the first line at or after pos.
+func lineStart(f io.ReaderAt, r run, pos int64) (int64, error) {
+	if pos == r.start {
+		return pos, nil
+	}
+	s, err := bufio.NewReaderSize(io.NewSectionReader(f, pos-1, r.end-pos+1), 256).ReadString('\n')
+	if err == io.EOF {
+		return r.end, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return pos - 1 + int64(len(s)), nil
+}
+
+// readLine returns the line at pos without the newline.
+func readLine(f io.ReaderAt, pos, end int64) (string, error) {
+	s, err := bufio.NewReaderSize(io.NewSectionReader(f, pos, end-pos), 256).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("incomplete line at %d", pos)
+	}
+	return s[:len(s)-1], nil
+}
+
+func lineKey(line string) string {
+	key, _, _ := strings.Cut(line, "\t")
+	return key
+}
+
+// readEntries reads the entries at the ascending offsets with one reader,
+// seeking only when they are not contiguous.
+func readEntries(filename string, offsets []int64) ([]*Entry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("open pdns data file %s failed %s", filename, err)
+	}
+	defer f.Close()
+
+	entries := []*Entry{}
+	reader := bufio.NewReader(f)
+	pos := int64(0)
+	for _, offset := range offsets {
+		if offset != pos {
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("seek pdns data file %s failed %s", filename, err)
+			}
+			reader.Reset(f)
+			pos = offset
+		}
+
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("read pdns entry at %d failed %s", offset, err)
+		}
+		pos += int64(len(line))
+
+		e := &Entry{}
+		if err := json.Unmarshal(line, e); err != nil {
+			return nil, fmt.Errorf("decode pdns entry at %d failed %s", offset, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
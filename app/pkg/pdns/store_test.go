@@ -0,0 +1,141 @@
+package pdns
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/types/dnslogtest"
+)
+
+func entry(name, rrtype, rdata string, first, last int) *Entry {
+	return &Entry{
+		RRName:    name,
+		RRType:    rrtype,
+		Rdata:     rdata,
+		FirstSeen: dnslogtest.Base.Add(time.Duration(first) * time.Hour),
+		LastSeen:  dnslogtest.Base.Add(time.Duration(last) * time.Hour),
+		Count:     1,
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append([]*Entry{
+		entry("www.example.com.", "A", "192.0.2.1", 0, 1),
+		entry("mail.example.com.", "A", "192.0.2.2", 0, 1),
+		entry("www.example.com.", "CNAME", "cdn.example.net.", 0, 1),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append([]*Entry{entry("www.example.com.", "A", "192.0.2.1", 48, 50)}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	es, err := Lookup(dir, Query{Name: "WWW.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 || es[0].Rdata != "192.0.2.1" || es[0].Count != 2 || !es[0].FirstSeen.Equal(dnslogtest.Base) || !es[0].LastSeen.Equal(dnslogtest.Base.Add(50*time.Hour)) {
+		t.Fatalf("forward lookup should merge entries but got %+v", es)
+	}
+
+	for q, want := range map[Query]int{
+		{Name: "*.example.com."}:    3,
+		{Rdata: "192.0.2.0/30"}:     2,
+		{Rdata: "192.0.2.2"}:        1,
+		{Rdata: "cdn.example.net."}: 1,
+		{Name: "*.example.com.", Start: dnslogtest.Base.Add(24 * time.Hour)}: 1,
+		{Name: "*.example.com.", End: dnslogtest.Base.Add(-time.Hour)}:       0,
+	} {
+		es, err := Lookup(dir, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(es) != want {
+			t.Fatalf("query %+v should find %d entries but find %d", q, want, len(es))
+		}
+	}
+}
+
+func TestStoreRuns(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := 0; r < 3; r++ {
+		entries := []*Entry{}
+		for i := 0; i < 200; i++ {
+			entries = append(entries, entry(fmt.Sprintf("h%d.z%d.example.com.", i, r), "A", fmt.Sprintf("10.%d.%d.%d", r, i/256, i%256), r, r))
+		}
+		if err := s.Append(entries); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	// a run cut off by a crash is dropped on open
+	f, err := os.OpenFile(filepath.Join(dir, forwardFilename), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(runHeader + "100\ndn:com.")
+	f.Close()
+	if s, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	for q, want := range map[Query]int{
+		{Name: "h7.z1.example.com."}:   1,
+		{Name: "h7.z9.example.com."}:   0,
+		{Name: "*.z2.example.com."}:    200,
+		{Name: "*.example.com."}:       600,
+		{Name: "*."}:                   600,
+		{Rdata: "10.1.0.0/25"}:         128,
+		{Rdata: "10.0.0.199"}:          1,
+		{Rdata: "10.2.0.190-10.2.1.5"}: 10,
+	} {
+		es, err := Lookup(dir, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(es) != want {
+			t.Fatalf("query %+v should find %d entries but find %d", q, want, len(es))
+		}
+	}
+}
+
+func TestLookupCorruptEntry(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append([]*Entry{entry("www.example.com.", "A", "192.0.2.1", 0, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if err := os.WriteFile(filepath.Join(dir, dataFilename), []byte("{broken\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Lookup(dir, Query{Name: "www.example.com."}); err == nil {
+		t.Fatalf("corrupt entry should be reported")
+	}
+}
@@ -0,0 +1,236 @@
+package publicsuffix
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ruleNormal = 1 << iota
+	ruleWildcard
+	ruleException
+)
+
+//go:embed public_suffix_list.dat
+var embedded string
+
+var (
+	current     *List
+	currentOnce sync.Once
+)
+
+// List holds the rules of a public suffix list, the icann and private
+// sections alike. Wildcard rules are keyed by the name under the wildcard and
+// exception rules by the name without the leading !.
+type List struct {
+	rules map[string]uint8
+}
+
+func Parse(r io.Reader) (*List, error) {
+	l := &List{rules: map[string]uint8{}}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		rule := strings.ToLower(strings.Fields(line)[0])
+
+		kind := uint8(ruleNormal)
+		switch {
+		case strings.HasPrefix(rule, "!"):
+			kind, rule = ruleException, rule[1:]
+		case rule == "*":
+			continue
+		case strings.HasPrefix(rule, "*."):
+			kind, rule = ruleWildcard, rule[2:]
+		}
+
+		name, err := toAscii(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s %s", line, err)
+		}
+		l.rules[name] |= kind
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func Load(path string) (*List, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open public suffix list %s failed %s", path, err)
+	}
+	defer f.Close()
+
+	l, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("parse public suffix list %s failed %s", path, err)
+	}
+	return l, nil
+}
+
+// Embedded returns the list snapshot built into the program.
+func Embedded() *List {
+	l, err := Parse(strings.NewReader(embedded))
+	if err != nil {
+		panic(fmt.Sprintf("parse embedded public suffix list failed %s", err))
+	}
+	return l
+}
+
+// Use replaces the list used by the package functions, it is meant to be
+// called once at startup.
+func Use(l *List) {
+	currentOnce.Do(func() {})
+	current = l
+}
+
+func list() *List {
+	currentOnce.Do(func() {
+		current = Embedded()
+	})
+	return current
+}
+
+func PublicSuffix(name string) string {
+	return list().PublicSuffix(name)
+}
+
+func RegisteredDomain(name string) string {
+	return list().RegisteredDomain(name)
+}
+
+// PublicSuffix returns the public suffix of the name as a lowercase fqdn, the
+// last label when no rule matches.
+func (l *List) PublicSuffix(name string) string {
+	name, end, start := l.walk(name)
+	return fqdnAt(name, end, start)
+}
+
+// RegisteredDomain returns the public suffix plus one label as a lowercase
+// fqdn, or an empty string when the name is a public suffix itself. It does
+// not allocate for a lowercase fqdn, which is the usual case of a decoded
+// packet.
+func (l *List) RegisteredDomain(name string) string {
+	name, end, start := l.walk(name)
+	if start == 0 {
+		return ""
+	}
+	return fqdnAt(name, end, strings.LastIndexByte(name[:start-1], '.')+1)
+}
+
+// walk returns the lowercase name without leading dots, the end of its last
+// label and the offset the public suffix starts at. The rules are looked up
+// with substrings of the name so no label is copied.
+func (l *List) walk(name string) (string, int, int) {
+	name = strings.TrimLeft(strings.ToLower(name), ".")
+	end := len(strings.TrimRight(name, "."))
+	if end == 0 {
+		return name, 0, 0
+	}
+
+	labels := name[:end]
+	for i := 0; ; {
+		kind := l.rules[labels[i:]]
+		dot := strings.IndexByte(labels[i:], '.')
+		if kind&ruleException != 0 {
+			if dot < 0 {
+				return name, end, end
+			}
+			return name, end, i + dot + 1
+		}
+		if kind&ruleNormal != 0 || dot < 0 {
+			return name, end, i
+		}
+
+		next := i + dot + 1
+		if l.rules[labels[next:]]&ruleWildcard != 0 {
+			return name, end, i
+		}
+		i = next
+	}
+}
+
+// fqdnAt returns the labels of the name from start to end as a fqdn, sliced
+// out of the name when it already has the trailing dot.
+func fqdnAt(name string, end, start int) string {
+	if end < len(name) {
+		return name[start : end+1]
+	}
+	return name[start:end] + "."
+}
+
+// Zone returns the last depth labels of the name as a lowercase fqdn, the
+// whole name when it has fewer labels.
+func Zone(name string, depth int) string {
+	labels := split(name)
+	if depth < len(labels) {
+		labels = labels[len(labels)-depth:]
+	}
+	return join(labels)
+}
+
+func split(name string) []string {
+	name = strings.Trim(strings.ToLower(name), ".")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
+func join(labels []string) string {
+	return strings.Join(labels, ".") + "."
+}
+
+// Group tells how names are aggregated, by the registered domain, by the
+// last Depth labels, or by the name itself when neither is set.
+type Group struct {
+	Registered bool
+	Depth      int
+}
+
+// ParseGroup accepts an empty string, "registered" or a label count.
+func ParseGroup(s string) (Group, error) {
+	switch s {
+	case "":
+		return Group{}, nil
+	case "registered":
+		return Group{Registered: true}, nil
+	}
+
+	depth, err := strconv.Atoi(s)
+	if err != nil || depth <= 0 {
+		return Group{}, fmt.Errorf("invalid domain group %s", s)
+	}
+	return Group{Depth: depth}, nil
+}
+
+func (g Group) Enabled() bool {
+	return g.Registered || g.Depth > 0
+}
+
+// Key returns the group of the name, registered is the registered domain of
+// the name when known. A public suffix is its own registered domain group.
+func (g Group) Key(name, registered string) string {
+	switch {
+	case g.Registered && registered != "":
+		return registered
+	case g.Registered:
+		if registered = RegisteredDomain(name); registered != "" {
+			return registered
+		}
+		return join(split(name))
+	case g.Depth > 0:
+		return Zone(name, g.Depth)
+	}
+	return name
+}
@@ -0,0 +1,112 @@
+package publicsuffix
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegisteredDomain(t *testing.T) {
+	cases := map[string]string{
+		"www.example.com.":    "example.com.",
+		"WWW.Example.COM":     "example.com.",
+		"example.com.":        "example.com.",
+		"com.":                "",
+		"a.b.example.com.cn.": "example.com.cn.",
+		"foo.github.io.":      "foo.github.io.",
+		"a.b.c.kobe.jp.":      "b.c.kobe.jp.",
+		"www.ck.":             "www.ck.",
+		"a.www.ck.":           "www.ck.",
+		"b.test.ck.":          "b.test.ck.",
+		"host.unknowntld.":    "host.unknowntld.",
+		"www.xn--fiqs8s.":     "www.xn--fiqs8s.",
+		"b.a.xn--55qx5d.cn":   "a.xn--55qx5d.cn.",
+		".":                   "",
+	}
+	for name, want := range cases {
+		if got := RegisteredDomain(name); got != want {
+			t.Fatalf("registered domain of %s should be %s but %s", name, want, got)
+		}
+	}
+
+	if s := PublicSuffix("a.b.example.co.uk."); s != "co.uk." {
+		t.Fatalf("public suffix should be co.uk. but %s", s)
+	}
+}
+
+func TestRegisteredDomainAllocs(t *testing.T) {
+	l := Embedded()
+	allocs := testing.AllocsPerRun(100, func() {
+		l.RegisteredDomain("a.b.www.example.com.cn.")
+	})
+	if allocs != 0 {
+		t.Fatalf("registered domain of a lowercase fqdn should not allocate but %v allocs", allocs)
+	}
+}
+
+func TestParse(t *testing.T) {
+	l, err := Parse(strings.NewReader("// comment\ncom\n*.example\n!keep.example\n中国\n公司.cn\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"a.b.example.":       "a.b.example.",
+		"a.keep.example.":    "keep.example.",
+		"www.xn--fiqs8s.":    "www.xn--fiqs8s.",
+		"a.b.xn--55qx5d.cn.": "b.xn--55qx5d.cn.",
+	}
+	for name, want := range cases {
+		if got := l.RegisteredDomain(name); got != want {
+			t.Fatalf("registered domain of %s should be %s but %s", name, want, got)
+		}
+	}
+}
+
+func TestPunycode(t *testing.T) {
+	cases := map[string]string{
+		"中国":      "fiqs8s",
+		"公司":      "55qx5d",
+		"bücher":  "bcher-kva",
+		"münchen": "mnchen-3ya",
+	}
+	for label, want := range cases {
+		if got, _ := punycode(label); got != want {
+			t.Fatalf("punycode of %s should be %s but %s", label, want, got)
+		}
+	}
+}
+
+func TestZone(t *testing.T) {
+	if z := Zone("a.b.Example.com", 2); z != "example.com." {
+		t.Fatalf("zone should be example.com. but %s", z)
+	}
+	if z := Zone("com.", 3); z != "com." {
+		t.Fatalf("zone should be com. but %s", z)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	if _, err := ParseGroup("0"); err == nil {
+		t.Fatalf("zero depth should be invalid")
+	}
+
+	cases := []struct {
+		group string
+		name  string
+		want  string
+	}{
+		{"", "WWW.example.com.", "WWW.example.com."},
+		{"registered", "a.b.example.com.cn.", "example.com.cn."},
+		{"registered", "com.cn.", "com.cn."},
+		{"3", "a.b.example.com.", "b.example.com."},
+	}
+	for _, c := range cases {
+		g, err := ParseGroup(c.group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := g.Key(c.name, ""); got != c.want {
+			t.Fatalf("group %s key of %s should be %s but %s", c.group, c.name, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package publicsuffix
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	punyBase        = 36
+	punyTmin        = 1
+	punyTmax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// toAscii converts the unicode labels of a rule to their xn-- form, the
+// dns names seen on the wire are always ascii.
+func toAscii(name string) (string, error) {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if isAscii(label) {
+			continue
+		}
+		encoded, err := punycode(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = "xn--" + encoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+func isAscii(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// punycode encodes a label as rfc 3492 describes.
+func punycode(label string) (string, error) {
+	if !utf8.ValidString(label) {
+		return "", fmt.Errorf("invalid utf8 label %q", label)
+	}
+	runes := []rune(label)
+
+	out := []byte{}
+	for _, r := range runes {
+		if r < punyInitialN {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := rune(punyInitialN), 0, punyInitialBias
+	for handled < len(runes) {
+		m := rune(utf8.MaxRune)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (handled + 1)
+		n = m
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := k - bias
+				if t < punyTmin {
+					t = punyTmin
+				} else if t > punyTmax {
+					t = punyTmax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out), nil
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+
+	k := 0
+	for delta > ((punyBase-punyTmin)*punyTmax)/2 {
+		delta /= punyBase - punyTmin
+		k += punyBase
+	}
+	return k + (punyBase-punyTmin+1)*delta/(delta+punySkew)
+}
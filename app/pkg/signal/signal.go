@@ -8,7 +8,7 @@ import (
 )
 
 func WaitForInterrupt(cb func()) {
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	osig.Notify(ch, os.Interrupt, syscall.SIGTERM)
 	<-ch
 
@@ -22,7 +22,7 @@ func WithSignal(parent context.Context) context.Context {
 }
 
 func WithSignalEx(parent context.Context, cb func()) context.Context {
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	osig.Notify(ch, os.Interrupt, syscall.SIGTERM)
 
 	ctx, cancel := context.WithCancel(parent)
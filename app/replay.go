@@ -0,0 +1,14 @@
+package app
+
+import (
+	"github.com/hiwyw/dnscap-go/app/config"
+	"github.com/hiwyw/dnscap-go/app/handler/replayer"
+)
+
+// NewReplay builds an app which only replays the captured client queries
+// against the target server, the outputs enabled in the config are ignored.
+func NewReplay(cfg *config.Config, opt replayer.Option) *App {
+	a := newApp(cfg, true)
+	a.txHandlers = append(a.txHandlers, replayer.New(opt, a.classifier))
+	return a
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+
+	"github.com/hiwyw/dnscap-go/app/config"
+	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+)
+
+type hostRole struct {
+	addr     netip.Addr
+	answered int
+	queried  int
+}
+
+type roleDetector struct {
+	hosts   map[netip.Addr]*hostRole
+	packets int
+}
+
+func (a *App) getSelfIps() []netip.Prefix {
+	selfIps := a.cfg.GetSelfIps()
+	if len(selfIps) != 0 || !a.cfg.SelfIpsAuto {
+		return selfIps
+	}
+
+	var err error
+	switch a.cfg.SourceType {
+	case config.SourceTypePcap:
+		selfIps, err = detectDeviceIps(a.cfg.SourceDeviceName)
+	case config.SourceTypePcapFile:
+		selfIps, err = a.detectResolverIps()
+	}
+	if err != nil {
+		logger.Errorf("auto detect self ips failed %s", err)
+		return nil
+	}
+
+	if len(selfIps) == 0 {
+		logger.Warnf("auto detect self ips found nothing, all traffic treated as client side")
+	}
+	return selfIps
+}
+
+func detectDeviceIps(device string) ([]netip.Prefix, error) {
+	ifs, err := pcap.FindAllDevs()
+	if err != nil {
+		return nil, fmt.Errorf("find all devices failed %s", err)
+	}
+
+	for _, i := range ifs {
+		if i.Name != device {
+			continue
+		}
+
+		prefixes := []netip.Prefix{}
+		for _, address := range i.Addresses {
+			addr := iptrie.FromIP(address.IP)
+			if !addr.IsValid() {
+				continue
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			logger.Infof("auto detect self ip %s from device %s", addr, device)
+		}
+		return prefixes, nil
+	}
+	return nil, fmt.Errorf("device %s not found", device)
+}
+
+func (a *App) detectResolverIps() ([]netip.Prefix, error) {
+	d := &roleDetector{hosts: map[netip.Addr]*hostRole{}}
+	limit := a.cfg.GetSelfIpsAutoPackets()
+
+	for _, f := range a.cfg.SourcePcapFiles {
+		if d.packets >= limit {
+			break
+		}
+
+		handle, err := a.openPcapFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		// read packets synchronously, breaking out of the Packets channel
+		// would leave its goroutine blocked while the handle is closed
+		packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+		for d.packets < limit {
+			p, err := packetSource.NextPacket()
+			if err != nil {
+				if err != io.EOF {
+					logger.Warnf("read pcap file %s failed %s", f, err)
+				}
+				break
+			}
+			a.detectRole(d, p)
+		}
+		handle.Close()
+	}
+
+	resolvers := []*hostRole{}
+	servers, clients := 0, 0
+	for _, h := range d.hosts {
+		switch {
+		case h.answered > 0 && h.queried > 0:
+			resolvers = append(resolvers, h)
+		case h.answered > 0:
+			servers++
+		default:
+			clients++
+		}
+	}
+	sort.Slice(resolvers, func(i, j int) bool {
+		return resolvers[i].answered > resolvers[j].answered
+	})
+
+	logger.Infof("auto detect roles from %d dns packets, %d resolvers %d servers %d clients", d.packets, len(resolvers), servers, clients)
+
+	prefixes := []netip.Prefix{}
+	for _, h := range resolvers {
+		logger.Infof("auto detect resolver %s answered %d queried %d", h.addr, h.answered, h.queried)
+		prefixes = append(prefixes, netip.PrefixFrom(h.addr, h.addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func (a *App) detectRole(d *roleDetector, p gopacket.Packet) {
+	dl, err := a.unpack(p)
+	if err != nil {
+		return
+	}
+	d.packets++
+
+	host := func(addr netip.Addr) *hostRole {
+		h, ok := d.hosts[addr]
+		if !ok {
+			h = &hostRole{addr: addr}
+			d.hosts[addr] = h
+		}
+		return h
+	}
+
+	if dl.Response {
+		if _, ok := a.dnsPorts[dl.SrcPort]; ok {
+			host(iptrie.FromIP(dl.SrcIP)).answered++
+		}
+		return
+	}
+
+	if _, ok := a.dnsPorts[dl.DstPort]; ok {
+		host(iptrie.FromIP(dl.SrcIP)).queried++
+	}
+}
@@ -4,25 +4,63 @@ import (
 	"time"
 
 	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/hiwyw/dnscap-go/app/types"
 )
 
 func New(size int) *SessionCache {
 	lruc, _ := lru.New(size)
 
 	return &SessionCache{
-		c: lruc,
+		c:    lruc,
+		size: size,
 	}
 }
 
 type SessionCache struct {
-	c *lru.Cache
+	c    *lru.Cache
+	size int
 }
 
-func (s *SessionCache) Add(k SessionKey, v SessionValue) (evicted bool) {
-	evicted = s.c.Add(k, v)
+func (s *SessionCache) Add(k SessionKey, v SessionValue) (evicted *SessionValue) {
+	if !s.c.Contains(k) && s.c.Len() >= s.size {
+		if _, ov, ok := s.c.GetOldest(); ok {
+			ev := ov.(SessionValue)
+			evicted = &ev
+		}
+	}
+	s.c.Add(k, v)
 	return
 }
 
+func (s *SessionCache) Expire(deadline time.Time) []SessionValue {
+	expired := []SessionValue{}
+	for {
+		_, ov, ok := s.c.GetOldest()
+		if !ok {
+			return expired
+		}
+
+		v := ov.(SessionValue)
+		if !v.QueryTime.Before(deadline) {
+			return expired
+		}
+		s.c.RemoveOldest()
+		expired = append(expired, v)
+	}
+}
+
+func (s *SessionCache) Purge() []SessionValue {
+	values := []SessionValue{}
+	for _, k := range s.c.Keys() {
+		if v, ok := s.c.Peek(k); ok {
+			values = append(values, v.(SessionValue))
+		}
+	}
+	s.c.Purge()
+	return values
+}
+
 func (s *SessionCache) Delete(k SessionKey) {
 	s.c.Remove(k)
 }
@@ -44,7 +82,10 @@ type SessionKey struct {
 }
 
 type SessionValue struct {
-	QueryTime time.Time
-	QueryType string
-	Domain    string
+	QueryTime      time.Time
+	FirstQueryTime time.Time
+	Retries        int
+	QueryType      string
+	Domain         string
+	Query          *types.Dnslog
 }
@@ -93,3 +93,28 @@ func TestSessionWithEvict(t *testing.T) {
 
 	sc.Add(queryKey3, queryValue)
 }
+
+func TestSessionExpire(t *testing.T) {
+	sc := New(3)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		k := SessionKey{SrcIP: "10.10.10.10", DstIP: "20.20.20.20", SrcPort: 56789, DstPort: 53, TransID: uint16(i)}
+		if ev := sc.Add(k, SessionValue{QueryTime: now.Add(time.Duration(i) * time.Second)}); ev != nil {
+			t.Fatalf("should not evict but evict %v", ev)
+		}
+	}
+
+	k := SessionKey{SrcIP: "10.10.10.10", DstIP: "20.20.20.20", SrcPort: 56789, DstPort: 53, TransID: 3}
+	ev := sc.Add(k, SessionValue{QueryTime: now.Add(3 * time.Second)})
+	if ev == nil || !ev.QueryTime.Equal(now) {
+		t.Fatalf("should evict oldest but got %v", ev)
+	}
+
+	if expired := sc.Expire(now.Add(2500 * time.Millisecond)); len(expired) != 2 {
+		t.Fatalf("should expire 2 but expire %d", len(expired))
+	}
+
+	if left := sc.Purge(); len(left) != 1 || !left[0].QueryTime.Equal(now.Add(3*time.Second)) {
+		t.Fatalf("should purge 1 but purge %v", left)
+	}
+}
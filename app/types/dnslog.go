@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/miekg/dns"
+
+	"github.com/hiwyw/dnscap-go/app/pkg/publicsuffix"
 )
 
 func DnslogFromMsg(msg *dns.Msg, dl *Dnslog) {
@@ -16,55 +18,96 @@ func DnslogFromMsg(msg *dns.Msg, dl *Dnslog) {
 		dl.Domain = msg.Question[0].Name
 		dl.QueryClass = dns.ClassToString[msg.Question[0].Qclass]
 		dl.QueryType = dns.TypeToString[msg.Question[0].Qtype]
+		dl.RegisteredDomain = publicsuffix.RegisteredDomain(dl.Domain)
 	}
 
 	dl.Response = msg.Response
-	dl.Authoritative = msg.Response
+	dl.Authoritative = msg.Authoritative
 	dl.Truncated = msg.Truncated
 	dl.RecursionDesired = msg.RecursionDesired
 	dl.RecursionAvailable = msg.RecursionAvailable
 	dl.Zero = msg.Zero
-
-	rrs2Strings := func(rrs []dns.RR) []string {
-		result := []string{}
-		for _, r := range rrs {
-			rs := strings.Join(strings.Split(strings.ReplaceAll(r.String(), "\n", ""), "\t"), " ")
-			result = append(result, rs)
-		}
-		return result
-	}
+	dl.AuthenticatedData = msg.AuthenticatedData
+	dl.CheckingDisabled = msg.CheckingDisabled
+	dl.Edns = msg.IsEdns0() != nil
 
 	if dl.Response {
 		dl.Rcode = dns.RcodeToString[msg.Rcode]
-		dl.Answer = rrs2Strings(msg.Answer)
-		dl.Authority = rrs2Strings(msg.Ns)
-		dl.Additional = rrs2Strings(msg.Extra)
+		dl.Answer = NewRRs(msg.Answer)
+		dl.Authority = NewRRs(msg.Ns)
+		dl.Additional = NewRRs(msg.Extra)
 	}
 }
 
+const (
+	TransactionAnswered   = "answered"
+	TransactionUnanswered = "unanswered"
+	TransactionOrphan     = "orphan"
+)
+
+func NewTransaction(query, response *Dnslog) *Dnslog {
+	if response == nil {
+		tx := *query
+		tx.Status = TransactionUnanswered
+		tx.PacketTime = query.FirstQueryTime
+		tx.QueryTime = query.FirstQueryTime
+		return &tx
+	}
+
+	tx := *response
+	tx.SrcIP, tx.DstIP = response.DstIP, response.SrcIP
+	tx.SrcPort, tx.DstPort = response.DstPort, response.SrcPort
+	tx.ResponseTime = response.PacketTime
+
+	if query == nil {
+		tx.Status = TransactionOrphan
+		return &tx
+	}
+
+	tx.Status = TransactionAnswered
+	tx.PacketTime = query.FirstQueryTime
+	tx.QueryTime = query.FirstQueryTime
+	tx.RecursionDesired = query.RecursionDesired
+	tx.CheckingDisabled = query.CheckingDisabled
+	tx.Zero = query.Zero
+	tx.QuerySize = query.QuerySize
+	return &tx
+}
+
 type Dnslog struct {
-	PacketTime         time.Time
-	SrcIP              net.IP
-	DstIP              net.IP
-	SrcPort            uint16
-	DstPort            uint16
-	TransID            uint16
-	Domain             string
-	QueryClass         string
-	QueryType          string
-	Rcode              string
-	Response           bool
-	Authoritative      bool
-	Truncated          bool
-	RecursionDesired   bool
-	RecursionAvailable bool
-	Zero               bool
-	AuthenticatedData  bool
-	CheckingDisabled   bool
-	ResolvDuration     time.Duration
-	Answer             []string
-	Authority          []string
-	Additional         []string
+	PacketTime          time.Time
+	SrcIP               net.IP
+	DstIP               net.IP
+	SrcPort             uint16
+	DstPort             uint16
+	TransID             uint16
+	Domain              string
+	RegisteredDomain    string
+	QueryClass          string
+	QueryType           string
+	Rcode               string
+	Response            bool
+	Authoritative       bool
+	Truncated           bool
+	RecursionDesired    bool
+	RecursionAvailable  bool
+	Zero                bool
+	AuthenticatedData   bool
+	CheckingDisabled    bool
+	Edns                bool
+	Heuristic           bool
+	ResolvDuration      time.Duration
+	FirstResolvDuration time.Duration
+	FirstQueryTime      time.Time
+	Retries             int
+	Status              string
+	QueryTime           time.Time
+	ResponseTime        time.Time
+	Answer              RRs
+	Authority           RRs
+	Additional          RRs
+	QuerySize           int
+	ResponseSize        int
 }
 
 func (d *Dnslog) String() string {
@@ -76,6 +119,9 @@ func (d *Dnslog) String() string {
 	}
 
 	getPacketType := func(response bool) string {
+		if d.Status != "" {
+			return d.Status
+		}
 		if response {
 			return "response"
 		}
@@ -100,9 +146,24 @@ func (d *Dnslog) String() string {
 		bool2Int(d.RecursionAvailable),
 		bool2Int(d.Zero),
 		strconv.FormatInt(d.ResolvDuration.Microseconds(), 10),
-		strings.Join(d.Answer, ";"),
-		strings.Join(d.Authority, ";"),
-		strings.Join(d.Additional, ";"),
+		d.Answer.String(),
+		d.Authority.String(),
+		d.Additional.String(),
+	}
+
+	// transaction records carry the flags and time of both messages
+	if d.Status != "" {
+		responseTime := ""
+		if !d.ResponseTime.IsZero() {
+			responseTime = d.ResponseTime.Local().Format("2006-01-02 15:04:05.999999")
+		}
+		ss = append(ss,
+			bool2Int(d.AuthenticatedData),
+			bool2Int(d.CheckingDisabled),
+			responseTime,
+			strconv.Itoa(d.Retries),
+			strconv.FormatInt(d.FirstResolvDuration.Microseconds(), 10),
+		)
 	}
 	return strings.Join(ss, "|")
 }
@@ -0,0 +1,44 @@
+package types
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDnslogString(t *testing.T) {
+	at := time.Date(2023, 8, 30, 16, 3, 20, 467226000, time.Local)
+	dl := &Dnslog{
+		PacketTime:          at,
+		SrcIP:               net.ParseIP("10.1.136.253"),
+		DstIP:               net.ParseIP("192.168.219.22"),
+		SrcPort:             53,
+		DstPort:             58938,
+		TransID:             18900,
+		Domain:              "www.qq.com.",
+		QueryClass:          "IN",
+		QueryType:           "A",
+		Rcode:               "NOERROR",
+		Response:            true,
+		Authoritative:       true,
+		RecursionDesired:    true,
+		RecursionAvailable:  true,
+		ResolvDuration:      5160 * time.Microsecond,
+		FirstResolvDuration: 6000 * time.Microsecond,
+		Retries:             1,
+		CheckingDisabled:    true,
+	}
+
+	packet := "2023-08-30 16:03:20.467226|10.1.136.253|192.168.219.22|53|58938|18900|response|www.qq.com.|IN|A|NOERROR|1|0|1|1|0|5160|||"
+	if s := dl.String(); s != packet {
+		t.Fatalf("packet line got %q want %q", s, packet)
+	}
+
+	dl.Status = TransactionAnswered
+	dl.ResponseTime = at.Add(5160 * time.Microsecond)
+	tx := strings.Replace(packet, "|response|", "|answered|", 1) + "|0|1|2023-08-30 16:03:20.472386|1|6000"
+	if s := dl.String(); s != tx {
+		t.Fatalf("transaction line got %q want %q", s, tx)
+	}
+}
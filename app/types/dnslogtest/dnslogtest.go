@@ -0,0 +1,58 @@
+// Package dnslogtest builds dns logs shared by the handler tests.
+package dnslogtest
+
+import (
+	"net"
+	"reflect"
+	"time"
+
+	"github.com/hiwyw/dnscap-go/app/types"
+)
+
+// Base is the packet time the built dns logs are offset from.
+var Base = time.Date(2023, 10, 24, 10, 0, 0, 0, time.UTC)
+
+// Packet returns an A query or a NOERROR response for domain sent ms
+// milliseconds after Base, answer records are in presentation format.
+func Packet(ms int, src, dst string, sport, dport, id uint16, domain string, response bool, answer ...string) *types.Dnslog {
+	dl := &types.Dnslog{
+		PacketTime: Base.Add(time.Duration(ms) * time.Millisecond),
+		SrcIP:      net.ParseIP(src),
+		DstIP:      net.ParseIP(dst),
+		SrcPort:    sport,
+		DstPort:    dport,
+		TransID:    id,
+		Domain:     domain,
+		QueryType:  "A",
+		Response:   response,
+	}
+	if response {
+		dl.Rcode = "NOERROR"
+		dl.Answer = RRs(answer...)
+	}
+	return dl
+}
+
+// RRs parses records in presentation format and panics on a bad record.
+func RRs(ss ...string) types.RRs {
+	rrs := types.RRs{}
+	for _, s := range ss {
+		rr, err := types.ParseRR(s)
+		if err != nil {
+			panic(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+// Kinds returns the Kind field of each finding in fs, a slice of struct
+// pointers, for failure messages.
+func Kinds(fs any) []string {
+	v := reflect.ValueOf(fs)
+	result := []string{}
+	for i := 0; i < v.Len(); i++ {
+		result = append(result, v.Index(i).Elem().FieldByName("Kind").String())
+	}
+	return result
+}
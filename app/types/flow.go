@@ -0,0 +1,24 @@
+package types
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	TransportUdp = "udp"
+	TransportTcp = "tcp"
+)
+
+// Flow is the transport header of a captured packet, handed to the flow
+// handlers before the payload is decoded as dns.
+type Flow struct {
+	PacketTime time.Time
+	SrcIP      net.IP
+	DstIP      net.IP
+	SrcPort    uint16
+	DstPort    uint16
+	Transport  string
+	Length     int
+	Syn        bool
+}
@@ -0,0 +1,166 @@
+package types
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// RR is a resource record with the header and the rdata of common types
+// parsed into fields. Rdata keeps the presentation form of the rdata for
+// types without parsed fields.
+type RR struct {
+	Name       string            `json:"name"`
+	Ttl        uint32            `json:"ttl"`
+	Class      string            `json:"class"`
+	Type       string            `json:"type"`
+	Rdata      string            `json:"rdata"`
+	Address    net.IP            `json:"address,omitempty"`
+	Target     string            `json:"target,omitempty"`
+	Preference uint16            `json:"preference,omitempty"`
+	Priority   uint16            `json:"priority,omitempty"`
+	Weight     uint16            `json:"weight,omitempty"`
+	Port       uint16            `json:"port,omitempty"`
+	Txt        []string          `json:"txt,omitempty"`
+	Soa        *SOA              `json:"soa,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	text       string
+}
+
+type SOA struct {
+	Ns      string `json:"ns"`
+	Mbox    string `json:"mbox"`
+	Serial  uint32 `json:"serial"`
+	Refresh uint32 `json:"refresh"`
+	Retry   uint32 `json:"retry"`
+	Expire  uint32 `json:"expire"`
+	Minttl  uint32 `json:"minttl"`
+}
+
+type RRs []RR
+
+func NewRR(r dns.RR) RR {
+	h := r.Header()
+	s := r.String()
+	rr := RR{
+		Name:  h.Name,
+		Ttl:   h.Ttl,
+		Class: dns.ClassToString[h.Class],
+		Type:  dns.TypeToString[h.Rrtype],
+		text:  flatten(s),
+	}
+	if rr.Class == "" {
+		rr.Class = dns.Class(h.Class).String()
+	}
+	if rr.Type == "" {
+		rr.Type = dns.Type(h.Rrtype).String()
+	}
+	rr.Rdata = flatten(strings.TrimPrefix(s, h.String()))
+
+	switch v := r.(type) {
+	case *dns.A:
+		rr.Address = v.A
+	case *dns.AAAA:
+		rr.Address = v.AAAA
+	case *dns.CNAME:
+		rr.Target = v.Target
+	case *dns.DNAME:
+		rr.Target = v.Target
+	case *dns.NS:
+		rr.Target = v.Ns
+	case *dns.PTR:
+		rr.Target = v.Ptr
+	case *dns.MX:
+		rr.Preference = v.Preference
+		rr.Target = v.Mx
+	case *dns.SRV:
+		rr.Priority = v.Priority
+		rr.Weight = v.Weight
+		rr.Port = v.Port
+		rr.Target = v.Target
+	case *dns.TXT:
+		rr.Txt = v.Txt
+	case *dns.SOA:
+		rr.Soa = &SOA{
+			Ns:      v.Ns,
+			Mbox:    v.Mbox,
+			Serial:  v.Serial,
+			Refresh: v.Refresh,
+			Retry:   v.Retry,
+			Expire:  v.Expire,
+			Minttl:  v.Minttl,
+		}
+	case *dns.SVCB:
+		rr.setSvcb(v)
+	case *dns.HTTPS:
+		rr.setSvcb(&v.SVCB)
+	}
+	return rr
+}
+
+// ParseRR parses a record in zone file presentation format.
+func ParseRR(s string) (RR, error) {
+	r, err := dns.NewRR(s)
+	if err != nil {
+		return RR{}, err
+	}
+	return NewRR(r), nil
+}
+
+func NewRRs(rrs []dns.RR) RRs {
+	result := RRs{}
+	for _, r := range rrs {
+		result = append(result, NewRR(r))
+	}
+	return result
+}
+
+func (rr *RR) setSvcb(v *dns.SVCB) {
+	rr.Priority = v.Priority
+	rr.Target = v.Target
+	if len(v.Value) > 0 {
+		rr.Params = map[string]string{}
+		for _, kv := range v.Value {
+			rr.Params[kv.Key().String()] = kv.String()
+		}
+	}
+}
+
+// String renders the record in one line, tab separators replaced by spaces.
+func (rr RR) String() string {
+	return rr.text
+}
+
+// Key identifies the record data regardless of ttl and case.
+func (rr RR) Key() string {
+	return strings.ToLower(strings.Join([]string{rr.Name, rr.Class, rr.Type, rr.Rdata}, " "))
+}
+
+// Keys returns the sorted keys of the records, two rrsets with the same keys
+// only differ in ttl, order or case.
+func (rrs RRs) Keys() []string {
+	result := []string{}
+	for _, rr := range rrs {
+		result = append(result, rr.Key())
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (rrs RRs) Strings() []string {
+	result := []string{}
+	for _, rr := range rrs {
+		result = append(result, rr.String())
+	}
+	return result
+}
+
+func (rrs RRs) String() string {
+	return strings.Join(rrs.Strings(), ";")
+}
+
+func flatten(s string) string {
+	return strings.Join(strings.Split(strings.ReplaceAll(s, "\n", ""), "\t"), " ")
+}
@@ -0,0 +1,55 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNewRR(t *testing.T) {
+	for _, s := range []string{
+		"www.example.com. 300 IN A 192.0.2.1",
+		"www.example.com. 300 IN AAAA 2001:db8::1",
+		"www.example.com. 300 IN CNAME cdn.example.net.",
+		"example.com. 300 IN MX 10 mail.example.com.",
+		"example.com. 300 IN NS ns1.example.com.",
+		"example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+		"example.com. 300 IN TXT \"v=spf1 -all\"",
+		"_sip._udp.example.com. 300 IN SRV 10 20 5060 sip.example.com.",
+		"1.2.0.192.in-addr.arpa. 300 IN PTR www.example.com.",
+		"example.com. 300 IN HTTPS 1 . alpn=\"h2,h3\"",
+	} {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := NewRR(r)
+		if rr.String() != flatten(r.String()) {
+			t.Fatalf("%s should render as %q but got %q", s, flatten(r.String()), rr.String())
+		}
+		if rr.Ttl != 300 || rr.Class != "IN" || rr.Type != dns.TypeToString[r.Header().Rrtype] {
+			t.Fatalf("%s parse header failed %+v", s, rr)
+		}
+	}
+
+	rr, _ := ParseRR("_sip._udp.example.com. 300 IN SRV 10 20 5060 sip.example.com.")
+	if rr.Priority != 10 || rr.Weight != 20 || rr.Port != 5060 || rr.Target != "sip.example.com." || rr.Rdata != "10 20 5060 sip.example.com." {
+		t.Fatalf("parse srv failed %+v", rr)
+	}
+
+	rr, _ = ParseRR("example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300")
+	if rr.Soa == nil || rr.Soa.Serial != 1 || rr.Soa.Minttl != 300 {
+		t.Fatalf("parse soa failed %+v", rr)
+	}
+
+	rr, _ = ParseRR("example.com. 300 IN HTTPS 1 . alpn=\"h2,h3\"")
+	if rr.Priority != 1 || rr.Target != "." || rr.Params["alpn"] != "h2,h3" {
+		t.Fatalf("parse https failed %+v", rr)
+	}
+
+	rr, _ = ParseRR("www.example.com. 300 IN A 192.0.2.1")
+	if rr.Address.String() != "192.0.2.1" {
+		t.Fatalf("parse a failed %+v", rr)
+	}
+}
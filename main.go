@@ -2,15 +2,24 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"os"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/google/gopacket/pcap"
 	"github.com/hiwyw/dnscap-go/app"
 	"github.com/hiwyw/dnscap-go/app/config"
+	"github.com/hiwyw/dnscap-go/app/handler/exporter"
+	"github.com/hiwyw/dnscap-go/app/handler/replayer"
 	"github.com/hiwyw/dnscap-go/app/logger"
+	"github.com/hiwyw/dnscap-go/app/pkg/iptrie"
+	"github.com/hiwyw/dnscap-go/app/pkg/pdns"
 	"github.com/hiwyw/dnscap-go/app/pkg/signal"
 )
 
@@ -25,6 +34,20 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			replay(os.Args[2:])
+			return
+		case "export":
+			export(os.Args[2:])
+			return
+		case "pdns":
+			pdnsQuery(os.Args[2:])
+			return
+		}
+	}
+
 	flag.StringVar(&configFile, "config", "config.yaml", "config file")
 	flag.BoolVar(&genConfig, "gen", false, "gen demo config file")
 	flag.BoolVar(&printVersion, "version", false, "print version")
@@ -74,3 +97,131 @@ func main() {
 
 	a.Run()
 }
+
+func replay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.StringVar(&configFile, "config", "config.yaml", "config file")
+	target := fs.String("target", "", "target dns server, ip:port")
+	speed := fs.Float64("speed", 1, "replay speed relative to the capture, 0 means as fast as possible")
+	timeout := fs.Duration("timeout", 2*time.Second, "query timeout")
+	concurrency := fs.Int("concurrency", 100, "max outstanding queries")
+	out := fs.String("out", "replay.json", "replay result filename")
+	diff := fs.String("diff", "replay_diff.log", "replay difference log filename")
+	fs.Parse(args)
+
+	if *target == "" {
+		log.Fatalf("replay target empty")
+	}
+	if _, _, err := net.SplitHostPort(*target); err != nil {
+		*target = net.JoinHostPort(*target, "53")
+	}
+
+	c := config.Load(configFile)
+	a := app.NewReplay(c, replayer.Option{
+		Target:       *target,
+		Speed:        *speed,
+		Timeout:      *timeout,
+		Concurrency:  *concurrency,
+		Filename:     path.Join(c.OutputDir, *out),
+		DiffFilename: path.Join(c.OutputDir, *diff),
+	})
+
+	signal.WithSignalEx(context.Background(), func() {
+		a.Stop()
+		logger.Infof("main groutinue exitting")
+	})
+
+	a.Run()
+}
+
+func export(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&configFile, "config", "config.yaml", "config file")
+	out := fs.String("out", "queries.txt", "dnsperf and resperf query filename")
+	timing := fs.String("timing", "", "query filename with time offset, empty means not output")
+	rate := fs.String("rate", "", "per second query rate filename, empty means not output")
+	dedup := fs.Bool("dedup", false, "only keep the first query of the same qname and qtype")
+	sample := fs.Int("sample", 0, "keep 1/N query sessions, overrides sample_rate in config")
+	clients := fs.String("clients", "", "only export queries from these clients, comma separated ip, cidr or range")
+	fs.Parse(args)
+
+	c := config.Load(configFile)
+	if *sample > 0 {
+		c.SampleRate = *sample
+	}
+
+	opt := exporter.Option{
+		Filename: path.Join(c.OutputDir, *out),
+		Dedup:    *dedup,
+	}
+	if *timing != "" {
+		opt.TimingFilename = path.Join(c.OutputDir, *timing)
+	}
+	if *rate != "" {
+		opt.RateFilename = path.Join(c.OutputDir, *rate)
+	}
+	for _, s := range strings.Split(*clients, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		ps, err := iptrie.Parse(s)
+		if err != nil {
+			log.Fatalf("parse clients failed %s", err)
+		}
+		opt.Clients = append(opt.Clients, ps...)
+	}
+
+	a := app.NewExport(c, opt)
+
+	signal.WithSignalEx(context.Background(), func() {
+		a.Stop()
+		logger.Infof("main groutinue exitting")
+	})
+
+	a.Run()
+}
+
+func pdnsQuery(args []string) {
+	if len(args) == 0 || args[0] != "query" {
+		log.Fatalf("usage: %s pdns query [-config config.yaml] [-dir pdns_dir] -name|-rdata value", os.Args[0])
+	}
+
+	fs := flag.NewFlagSet("pdns query", flag.ExitOnError)
+	fs.StringVar(&configFile, "config", "config.yaml", "config file, used to locate pdns_dir when dir is empty")
+	dir := fs.String("dir", "", "passive dns store dir")
+	name := fs.String("name", "", "forward lookup by rrname, *.example.com. matches all names under example.com.")
+	rdata := fs.String("rdata", "", "reverse lookup by rdata, ip, cidr, range or domain name")
+	start := fs.String("start", "", "only entries seen after start time, format 2006-01-02 15:04:05 or rfc3339")
+	end := fs.String("end", "", "only entries seen before end time, format same as start")
+	asJson := fs.Bool("json", false, "print entries as json lines")
+	fs.Parse(args[1:])
+
+	if *dir == "" {
+		c := config.Load(configFile)
+		*dir = path.Join(c.OutputDir, c.PdnsDir)
+	}
+
+	q := pdns.Query{
+		Name:  *name,
+		Rdata: *rdata,
+		Start: config.ParseTime(*start),
+		End:   config.ParseTime(*end),
+	}
+
+	entries, err := pdns.Lookup(*dir, q)
+	if err != nil {
+		log.Fatalf("pdns query failed %s", err)
+	}
+
+	for _, e := range entries {
+		if *asJson {
+			b, _ := json.Marshal(e)
+			fmt.Println(string(b))
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%d\n", e.RRName, e.RRType, e.Rdata,
+			e.FirstSeen.Local().Format("2006-01-02 15:04:05"),
+			e.LastSeen.Local().Format("2006-01-02 15:04:05"),
+			e.Count)
+	}
+}